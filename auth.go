@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"engine/lib"
+	"engine/types"
+
+	"github.com/fatih/color"
+)
+
+type apiKeyContext struct{}
+
+var engineKeys []types.ApiKey
+
+/**
+ * Function to load the API keys, authentication is disabled when no keys file is given
+ */
+func LoadKeys(filename string) {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	if filename == "" {
+		log.Printf(red("[ Engine ] Authentication is disabled, every client can run flows"))
+		return
+	}
+
+	keys, errorKeys := lib.ReadKeys(filename)
+
+	if errorKeys != nil {
+		panic(fmt.Sprintf("Cannot read API keys %s, %v", filename, errorKeys))
+	}
+
+	engineKeys = keys
+
+	log.Printf("%s Using %d API key(s) from %s", yellow("[ Engine ]"), len(keys), filename)
+}
+
+/**
+ * Function to find the API key from Authorization bearer token or X-Api-Key header
+ */
+func Authenticate(r *http.Request) *types.ApiKey {
+	token := r.Header.Get("X-Api-Key")
+	authorization := r.Header.Get("Authorization")
+
+	if strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	if token == "" {
+		return nil
+	}
+
+	for index := range engineKeys {
+		if subtle.ConstantTimeCompare([]byte(engineKeys[index].Key), []byte(token)) == 1 {
+			return &engineKeys[index]
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Wrap the handler with CORS and API key authentication, the authenticated key
+ * is available to the handler using RequestKey
+ */
+func Protect(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(engineKeys) == 0 {
+			lib.Cors(&w, r, nil)
+
+			if r.Method == "OPTIONS" {
+				return
+			}
+
+			handler(w, r)
+			return
+		}
+
+		// Preflight request never carries the credentials, so allow every origin known by any key
+		if r.Method == "OPTIONS" {
+			lib.Cors(&w, r, AllowedOrigins())
+			return
+		}
+
+		key := Authenticate(r)
+
+		if key == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="engine"`)
+			lib.JSON(w, http.StatusUnauthorized, types.Result{Code: 401, Message: "Missing or invalid API key"})
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && len(key.Origins) > 0 && !lib.Contains(key.Origins, "*") && !lib.Contains(key.Origins, origin) {
+			lib.JSON(w, http.StatusForbidden, types.Result{Code: 403, Message: "Origin " + origin + " is not allowed for this API key"})
+			return
+		}
+
+		lib.Cors(&w, r, key.Origins)
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), apiKeyContext{}, key)))
+	}
+}
+
+/**
+ * Function to return every origin allowed by the configured API keys
+ */
+func AllowedOrigins() []string {
+	var origins []string
+
+	for _, key := range engineKeys {
+		for _, origin := range key.Origins {
+			if !lib.Contains(origins, origin) {
+				origins = append(origins, origin)
+			}
+		}
+	}
+
+	return origins
+}
+
+/**
+ * Function to return the API key of the request, nil when authentication is disabled
+ */
+func RequestKey(r *http.Request) *types.ApiKey {
+	key, _ := r.Context().Value(apiKeyContext{}).(*types.ApiKey)
+
+	return key
+}
+
+/**
+ * Function to find the API key by its name, nil when there is no such key
+ */
+func FindKey(name string) *types.ApiKey {
+	for index := range engineKeys {
+		if engineKeys[index].Name == name {
+			return &engineKeys[index]
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Function to return the name of the API key which owns the request
+ */
+func KeyName(key *types.ApiKey) string {
+	if key == nil {
+		return ""
+	}
+
+	return key.Name
+}
+
+/**
+ * Function to check whether the key may see or manage resources of the owner
+ */
+func IsOwner(key *types.ApiKey, owner string) bool {
+	return key == nil || key.Permissions.Admin || key.Name == owner
+}
+
+/**
+ * Function to check the flow against the permissions of the API key
+ */
+func Authorize(key *types.ApiKey, request types.Config) error {
+	if key == nil {
+		return nil
+	}
+
+	if request.Record && !key.Permissions.Record {
+		return fmt.Errorf("API key %s is not allowed to record the flow", key.Name)
+	}
+
+	return Walk(request.Flow, "", func(path string, flowData types.Flow) error {
+		if strings.Contains(flowData.Element.Write, "$") && !key.Permissions.Env {
+			return fmt.Errorf("API key %s is not allowed to read environment variable on step %s", key.Name, path)
+		}
+
+		if flowData.Element.Value != "" && !key.Permissions.Evaluate {
+			return fmt.Errorf("API key %s is not allowed to evaluate JavaScript on step %s", key.Name, path)
+		}
+
+		return nil
+	})
+}
@@ -107,6 +107,7 @@ func request(flows []string, current int, total int, errorGroup *errgroup.Group)
 		loading.Suffix = "  scraping website " + config.FirstPage
 		loading.Start()
 
+		// Output is converted by the connector, so the engine always responds with JSON
 		body := types.Config{
 			Name:           config.Name,
 			Engine:         config.Engine,
@@ -120,6 +121,17 @@ func request(flows []string, current int, total int, errorGroup *errgroup.Group)
 			Proxy:          config.Proxy,
 			ProxyCountry:   config.ProxyCountry,
 			Record:         config.Record,
+			LegacyJson:     config.LegacyJson,
+			ResultVersion:  config.ResultVersion,
+			Webhook:        config.Webhook,
+			Schedule:       config.Schedule,
+			Retention:      config.Retention,
+			Variables:      config.Variables,
+			FollowDepth:    config.FollowDepth,
+			Timeout:        config.Timeout,
+			Retries:        config.Retries,
+			RetryBackoff:   config.RetryBackoff,
+			OnError:        config.OnError,
 			Flow:           config.Flow,
 		}
 
@@ -143,6 +155,18 @@ func request(flows []string, current int, total int, errorGroup *errgroup.Group)
 
 		_ = ioutil.WriteFile(jsonPath, resultBody, 0644)
 
+		if config.Output != "" && config.Output != types.OutputJson {
+			exportPaths, errorExport := export(result, config.Output)
+
+			if errorExport != nil {
+				log.Printf(red("[OWL] Cannot export the result into %s : %v"), config.Output, errorExport)
+			}
+
+			for _, exportPath := range exportPaths {
+				log.Printf("%s Result exported : %s", blue("[OWL]"), green(exportPath))
+			}
+		}
+
 		end := time.Now()
 		log.Printf("%s Flow #%s finished in %s (s)", blue("[OWL]"), green(result.Id), green(end.Sub(start).Seconds()))
 		log.Printf("%s Flow closed", blue("[OWL]"))
@@ -160,6 +184,68 @@ func request(flows []string, current int, total int, errorGroup *errgroup.Group)
 	return false
 }
 
+/**
+ * Function to write the result in the output format of the flow next to the JSON result,
+ * CSV output is written as one file for the records and one file for every table
+ */
+func export(result types.Result, output string) ([]string, error) {
+	var paths []string
+
+	if !lib.ValidOutput(output) {
+		return nil, fmt.Errorf("unknown output %s, use json, csv, ndjson or xlsx", output)
+	}
+
+	sheets := lib.Sheets(result)
+	basePath := directory + "/resources/json/" + result.Slug
+
+	if output == types.OutputCsv {
+		for _, sheet := range sheets {
+			var buffer bytes.Buffer
+
+			errorWrite := lib.WriteCsv(&buffer, sheet)
+
+			if errorWrite != nil {
+				return paths, errorWrite
+			}
+
+			sheetPath := lib.SheetFile(basePath, sheet, "csv")
+
+			errorFile := ioutil.WriteFile(sheetPath, buffer.Bytes(), 0644)
+
+			if errorFile != nil {
+				return paths, errorFile
+			}
+
+			paths = append(paths, sheetPath)
+		}
+
+		return paths, nil
+	}
+
+	var buffer bytes.Buffer
+	var errorWrite error
+
+	if output == types.OutputXlsx {
+		errorWrite = lib.WriteXlsx(&buffer, sheets)
+	} else {
+		errorWrite = lib.WriteNdjson(&buffer, sheets)
+	}
+
+	if errorWrite != nil {
+		return paths, errorWrite
+	}
+
+	exportPath := basePath + "." + output
+
+	errorFile := ioutil.WriteFile(exportPath, buffer.Bytes(), 0644)
+
+	if errorFile != nil {
+		return paths, errorFile
+	}
+
+	return append(paths, exportPath), nil
+}
+
 /**
  * Function to parse YAML file into config struct
  */
@@ -178,6 +264,12 @@ func config(filename string) (*types.Config, error) {
 		log.Fatalf(red("[OWL] Cannot read flow file %q : %v"), filename, err)
 	}
 
+	err = lib.Resolve(c, filename, filepath.Dir(filename), filepath.Dir(filename))
+
+	if err != nil {
+		log.Fatalf(red("[OWL] Cannot resolve flow file %q : %v"), filename, err)
+	}
+
 	return c, nil
 }
 
@@ -186,7 +278,14 @@ func config(filename string) (*types.Config, error) {
  */
 func client(data types.Config, requestChan chan *http.Response) error {
 	body, _ := json.Marshal(data)
-	result, httpError := http.Post(data.Engine, "application/json", bytes.NewReader(body))
+	request, _ := http.NewRequest("POST", data.Engine, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	if apiKey := os.Getenv("ENGINE_API_KEY"); apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	result, httpError := http.DefaultClient.Do(request)
 
 	requestChan <- result
 
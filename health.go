@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"engine/lib"
+	"engine/types"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+var engineStarted = time.Now()
+var engineInFlight int64
+
+// Versions of the installed tools rarely change, the probe runs them at most once a minute
+var tesseractProbe = cached(lib.Tesseract, time.Minute)
+var ffmpegProbe = cached(lib.Ffmpeg, time.Minute)
+
+/**
+ * Handle the liveness probe, the process is up when it can answer
+ */
+func Health(w http.ResponseWriter, r *http.Request) {
+	lib.JSON(w, http.StatusOK, types.Health{
+		Status:  "ok",
+		Started: engineStarted,
+		Uptime:  time.Since(engineStarted) / 1000000,
+	})
+}
+
+/**
+ * Handle the readiness probe, every dependency of the engine is checked and
+ * the engine is ready only when all of them are working
+ */
+func Ready(w http.ResponseWriter, r *http.Request) {
+	readiness := types.Readiness{
+		Status:   "ready",
+		InFlight: atomic.LoadInt64(&engineInFlight),
+		Queued:   len(jobQueue),
+		Checks: []types.ReadinessCheck{
+			check("browser", CheckBrowser),
+			check("tesseract", tesseractProbe),
+			check("ffmpeg", ffmpegProbe),
+			check("images", writable(imagesDirectory)),
+			check("videos", writable(videoDirectory)),
+			check("logs", writable(logsDirectory)),
+		},
+	}
+
+	for _, readinessCheck := range readiness.Checks {
+		if readinessCheck.Status != "ok" {
+			readiness.Status = "not_ready"
+		}
+	}
+
+	if ShuttingDown() {
+		readiness.Status = "shutting_down"
+	}
+
+	if readiness.Status != "ready" {
+		lib.JSON(w, http.StatusServiceUnavailable, readiness)
+		return
+	}
+
+	lib.JSON(w, http.StatusOK, readiness)
+}
+
+/**
+ * Function to check the engine browser still responds over CDP and return its version
+ */
+func CheckBrowser() (string, error) {
+	var version *proto.BrowserGetVersionResult
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	errorBrowser := rod.Try(func() {
+		result, errorVersion := proto.BrowserGetVersion{}.Call(engineBrowser.Context(ctx))
+
+		if errorVersion != nil {
+			panic(errorVersion)
+		}
+
+		version = result
+	})
+
+	if errorBrowser != nil {
+		return "", errorBrowser
+	}
+
+	return version.Product, nil
+}
+
+func check(name string, probe func() (string, error)) types.ReadinessCheck {
+	start := time.Now()
+	version, errorProbe := probe()
+
+	readinessCheck := types.ReadinessCheck{
+		Name:     name,
+		Status:   "ok",
+		Version:  strings.TrimSpace(version),
+		Duration: time.Since(start) / 1000000,
+	}
+
+	if errorProbe != nil {
+		readinessCheck.Status = "fail"
+		readinessCheck.Message = errorProbe.Error()
+	}
+
+	return readinessCheck
+}
+
+// Returns the probe which keeps the result of the probe for the duration
+func cached(probe func() (string, error), duration time.Duration) func() (string, error) {
+	var mutex sync.Mutex
+	var checked time.Time
+	var version string
+	var errorProbe error
+
+	return func() (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if checked.IsZero() || time.Since(checked) > duration {
+			version, errorProbe = probe()
+			checked = time.Now()
+		}
+
+		return version, errorProbe
+	}
+}
+
+// Returns the probe which writes and removes a temporary file in the directory
+func writable(directory string) func() (string, error) {
+	return func() (string, error) {
+		file, errorCreate := ioutil.TempFile(directory, ".readyz-*")
+
+		if errorCreate != nil {
+			return "", errorCreate
+		}
+
+		file.Close()
+
+		return "", os.Remove(file.Name())
+	}
+}
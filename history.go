@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"engine/lib"
+	"engine/lib/store"
+	"engine/types"
+
+	"github.com/fatih/color"
+)
+
+var engineStore *store.Store
+
+// Runs listed by the history API when no limit is given, and the most runs listed at once
+const runsLimit = 100
+const runsMaximum = 1000
+
+/**
+ * Function to open the run history store, the engine keeps running without history when it fails
+ */
+func OpenStore() {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	storePath := os.Getenv(`STORE_PATH`)
+
+	if storePath == "" {
+		storePath = rootDirectory + "/data/engine.db"
+	}
+
+	openedStore, errorStore := store.Open(storePath)
+
+	if errorStore != nil {
+		log.Printf(red("[ Engine ] Run history is disabled, due to %v"), errorStore)
+		return
+	}
+
+	engineStore = openedStore
+
+	log.Printf("%s Using run history %s", yellow("[ Engine ]"), replacerPath.Replace(storePath))
+}
+
+/**
+ * Function to return short hash of the flow config, so runs of the same flow version can be grouped
+ */
+func FlowVersion(request types.Config) string {
+	data, _ := json.Marshal(request)
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+/**
+ * Function to add the webhook delivery log into the saved run
+ */
+func RecordDeliveries(id string, deliveries []types.WebhookDelivery) {
+	red := color.New(color.FgRed).SprintFunc()
+
+	if engineStore == nil || len(deliveries) == 0 {
+		return
+	}
+
+	errorUpdate := engineStore.Update(id, func(run *types.Run) {
+		run.Deliveries = append(run.Deliveries, deliveries...)
+	})
+
+	if errorUpdate != nil {
+		log.Printf(red("[ Engine ] Failed to save webhook deliveries of #%s, due to %v"), id, errorUpdate)
+	}
+}
+
+/**
+ * Function to save the finished run into the history store
+ */
+func Record(session *Session, result types.Result, created time.Time, started time.Time) {
+	red := color.New(color.FgRed).SprintFunc()
+
+	if engineStore == nil {
+		return
+	}
+
+	finished := time.Now()
+	config := session.Request
+
+	// Never keep the webhook secret in the history
+	config.Webhook.Secret = ""
+
+	run := types.Run{
+		Id:        session.Id,
+		Name:      session.Request.Name,
+		Owner:     session.Owner,
+		Status:    ResultStatus(result),
+		Version:   FlowVersion(session.Request),
+		Config:    config,
+		Result:    &result,
+		Errors:    result.Errors,
+		Usage:     result.Usage,
+		Artifacts: session.Artifacts(),
+		Created:   created,
+		Started:   started,
+		Finished:  finished,
+		Duration:  finished.Sub(started) / 1000000,
+	}
+
+	errorSave := engineStore.Save(run)
+
+	if errorSave != nil {
+		log.Printf(red("[ Engine ] Failed to save run #%s into history, due to %v"), session.Id, errorSave)
+	}
+}
+
+/**
+ * Handle the run history API
+ *
+ * GET /runs?name=&status=&since=&limit=  list of runs, newest first, 100 runs unless the limit is given
+ * GET /runs/{id}                         single run with config and result
+ * GET /runs/{id}/artifacts               fresh signed links of the run artifacts
+ */
+func Runs(w http.ResponseWriter, r *http.Request) {
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.JSON(w, http.StatusMethodNotAllowed, types.Result{Code: 405, Message: "Method not allowed for this request"})
+		return
+	}
+
+	if engineStore == nil {
+		lib.JSON(w, http.StatusServiceUnavailable, types.Result{Code: 503, Message: "Run history is disabled on this engine"})
+		return
+	}
+
+	key := RequestKey(r)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs"), "/")
+	segments := strings.Split(id, "/")
+	id = segments[0]
+
+	if id != "" {
+		run, errorGet := engineStore.Get(id)
+
+		if errorGet != nil {
+			lib.JSON(w, http.StatusInternalServerError, types.Result{Code: 500, Message: errorGet.Error()})
+			return
+		}
+
+		if run == nil || !IsOwner(key, run.Owner) {
+			lib.JSON(w, http.StatusNotFound, types.Result{Code: 404, Message: "Run not found for " + id})
+			return
+		}
+
+		// Fresh signed links of the artifacts, the links inside the result may be expired
+		if len(segments) == 2 && segments[1] == "artifacts" {
+			artifacts := make([]types.Artifact, 0, len(run.Artifacts))
+
+			for _, artifact := range run.Artifacts {
+				artifactKey := strings.TrimPrefix(artifact, "/resources/")
+
+				artifacts = append(artifacts, types.Artifact{
+					Key: artifactKey,
+					Url: engineStorage.Url(artifactKey),
+				})
+			}
+
+			lib.JSON(w, http.StatusOK, artifacts)
+			return
+		}
+
+		if len(segments) > 1 {
+			lib.JSON(w, http.StatusNotFound, types.Result{Code: 404, Message: "Run not found for " + id})
+			return
+		}
+
+		lib.JSON(w, http.StatusOK, run)
+		return
+	}
+
+	query := r.URL.Query()
+
+	filter := types.RunFilter{
+		Name:   query.Get("name"),
+		Status: query.Get("status"),
+	}
+
+	if key != nil && !key.Permissions.Admin {
+		filter.Owner = key.Name
+	}
+
+	if since := query.Get("since"); since != "" {
+		sinceTime, errorSince := ParseSince(since)
+
+		if errorSince != nil {
+			lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: "Parameter since must be RFC3339 time, date or duration"})
+			return
+		}
+
+		filter.Since = sinceTime
+	}
+
+	filter.Limit = runsLimit
+
+	if limit := query.Get("limit"); limit != "" {
+		parsedLimit, errorLimit := strconv.Atoi(limit)
+
+		if errorLimit != nil || parsedLimit < 1 {
+			lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: fmt.Sprintf("Parameter limit must be a number from 1 to %d", runsMaximum)})
+			return
+		}
+
+		filter.Limit = parsedLimit
+	}
+
+	if filter.Limit > runsMaximum {
+		filter.Limit = runsMaximum
+	}
+
+	runs, errorFind := engineStore.Find(filter)
+
+	if errorFind != nil {
+		lib.JSON(w, http.StatusInternalServerError, types.Result{Code: 500, Message: errorFind.Error()})
+		return
+	}
+
+	lib.JSON(w, http.StatusOK, runs)
+}
+
+/**
+ * Function to parse since parameter as RFC3339 time, plain date or duration ago (e.g. 24h)
+ */
+func ParseSince(since string) (time.Time, error) {
+	if sinceTime, errorTime := time.Parse(time.RFC3339, since); errorTime == nil {
+		return sinceTime, nil
+	}
+
+	if sinceDate, errorDate := time.ParseInLocation("2006-01-02", since, time.Local); errorDate == nil {
+		return sinceDate, nil
+	}
+
+	duration, errorDuration := time.ParseDuration(since)
+
+	if errorDuration != nil {
+		return time.Time{}, errorDuration
+	}
+
+	return time.Now().Add(-duration), nil
+}
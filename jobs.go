@@ -0,0 +1,603 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"engine/lib"
+	"engine/types"
+
+	"github.com/fatih/color"
+	"github.com/go-rod/rod"
+	"github.com/google/uuid"
+)
+
+type Job struct {
+	types.Job
+
+	owner     string
+	allowance *Allowance
+	request   types.Config
+	result    *types.Result
+	context   context.Context
+	cancel    context.CancelFunc
+
+	events []types.StepEvent
+	notify []chan struct{}
+	done   chan struct{}
+}
+
+var jobs = make(map[string]*Job)
+var jobsMutex sync.RWMutex
+var jobQueue chan *Job
+var jobTTL time.Duration
+
+/**
+ * Function to start the job queue and the workers which consume it
+ */
+func Queue() {
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	workers := lib.EnvInt(`MAX_CONCURRENT_JOBS`, 1)
+	queueSize := lib.EnvInt(`MAX_QUEUED_JOBS`, 1000)
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobTTL = time.Hour
+
+	if ttl := os.Getenv(`JOB_TTL`); ttl != "" {
+		parsedTTL, errorTTL := ParseAge(ttl)
+
+		if errorTTL != nil || parsedTTL <= 0 {
+			panic(fmt.Sprintf("Invalid JOB_TTL %s", ttl))
+		}
+
+		jobTTL = parsedTTL
+	}
+
+	jobQueue = make(chan *Job, queueSize)
+
+	for index := 0; index < workers; index++ {
+		go Worker()
+	}
+
+	go Evict()
+
+	log.Printf("%s Job queue started with %d worker(s), finished jobs are kept for %s", yellow("[ Engine ]"), workers, jobTTL)
+}
+
+/**
+ * Function to remove the finished jobs from memory once they are older than JOB_TTL,
+ * the run of the removed job is still in the run history
+ */
+func Evict() {
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	for !ShuttingDown() {
+		time.Sleep(time.Minute)
+
+		if evicted := evictJobs(time.Now().Add(-jobTTL)); evicted > 0 {
+			log.Printf("%s Removed %d finished job(s) older than %s", yellow("[ Engine ]"), evicted, jobTTL)
+		}
+	}
+}
+
+func evictJobs(before time.Time) int {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	evicted := 0
+
+	for id, job := range jobs {
+		if job.Finished != nil && job.Finished.Before(before) {
+			delete(jobs, id)
+			evicted++
+		}
+	}
+
+	return evicted
+}
+
+/**
+ * Function to run queued jobs one by one until the queue is closed
+ */
+func Worker() {
+	for job := range jobQueue {
+		job.run()
+	}
+}
+
+/**
+ * Handle the asynchronous job API
+ *
+ * POST   /jobs              submit a flow and return the job immediately
+ * GET    /jobs/{id}         current status of the job
+ * DELETE /jobs/{id}         cancel the queued or running job
+ * GET    /jobs/{id}/result  result of the finished job
+ * GET    /jobs/{id}/events  server-sent events for every executed step
+ */
+func Jobs(w http.ResponseWriter, r *http.Request) {
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+	segments := strings.Split(path, "/")
+
+	switch {
+	case path == "" && r.Method == "POST":
+		SubmitJob(w, r)
+	case path != "" && len(segments) == 1 && r.Method == "GET":
+		job := findJob(segments[0])
+
+		if job == nil || !IsOwner(RequestKey(r), job.owner) {
+			lib.JSON(w, http.StatusNotFound, types.Result{Code: 404, Message: "Job not found for " + segments[0]})
+			return
+		}
+
+		lib.JSON(w, http.StatusOK, job.status())
+	case path != "" && len(segments) == 1 && r.Method == "DELETE":
+		job := findJob(segments[0])
+
+		if job == nil || !IsOwner(RequestKey(r), job.owner) {
+			lib.JSON(w, http.StatusNotFound, types.Result{Code: 404, Message: "Job not found for " + segments[0]})
+			return
+		}
+
+		if !job.Cancel() {
+			lib.JSON(w, http.StatusConflict, job.status())
+			return
+		}
+
+		lib.JSON(w, http.StatusAccepted, job.status())
+	case len(segments) == 2 && segments[1] == "result" && r.Method == "GET":
+		job := findJob(segments[0])
+
+		if job == nil || !IsOwner(RequestKey(r), job.owner) {
+			lib.JSON(w, http.StatusNotFound, types.Result{Code: 404, Message: "Job not found for " + segments[0]})
+			return
+		}
+
+		if !lib.ValidOutput(Output(r, job.request)) {
+			lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: "Output must be json, csv, ndjson or xlsx"})
+			return
+		}
+
+		result := job.finalResult()
+
+		if result == nil {
+			lib.JSON(w, http.StatusAccepted, job.status())
+			return
+		}
+
+		lib.Export(w, *result, "", Output(r, job.request), job.request.LegacyJson)
+	case len(segments) == 2 && segments[1] == "events" && r.Method == "GET":
+		job := findJob(segments[0])
+
+		if job == nil || !IsOwner(RequestKey(r), job.owner) {
+			lib.JSON(w, http.StatusNotFound, types.Result{Code: 404, Message: "Job not found for " + segments[0]})
+			return
+		}
+
+		JobEvents(w, r, job)
+	default:
+		lib.JSON(w, http.StatusMethodNotAllowed, types.Result{Code: 405, Message: "Method not allowed for this request"})
+	}
+}
+
+/**
+ * Function to decode the flow from request body and put it into the job queue
+ */
+func SubmitJob(w http.ResponseWriter, r *http.Request) {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	var request types.Config
+
+	errorDecodeRequest := json.NewDecoder(r.Body).Decode(&request)
+
+	if errorDecodeRequest != nil {
+		http.Error(w, errorDecodeRequest.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if errorInclude := Include(&request); errorInclude != nil {
+		lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: errorInclude.Error()})
+		return
+	}
+
+	if len(request.Flow) == 0 {
+		lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: "Flow is empty, nothing to run"})
+		return
+	}
+
+	errorValidate := Validate(request)
+
+	if errorValidate != nil {
+		lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: errorValidate.Error()})
+		return
+	}
+
+	key := RequestKey(r)
+	errorAuthorize := Authorize(key, request)
+
+	if errorAuthorize != nil {
+		lib.JSON(w, http.StatusForbidden, types.Result{Code: 403, Message: errorAuthorize.Error()})
+		return
+	}
+
+	allowance, quotaCode, quotaMessage := Acquire(key)
+
+	if quotaCode != "" {
+		lib.JSON(w, http.StatusTooManyRequests, types.Result{Code: 429, ErrorCode: quotaCode, Message: quotaMessage})
+		return
+	}
+
+	if ShuttingDown() {
+		allowance.Release(types.ResultUsage{})
+
+		lib.JSON(w, http.StatusServiceUnavailable, types.Result{Code: 503, ErrorCode: types.EngineShuttingDown, Message: "The engine is shutting down, try again later"})
+		return
+	}
+
+	job := NewJob(request)
+	job.owner = KeyName(key)
+	job.allowance = allowance
+
+	if !Enqueue(job) {
+		allowance.Release(types.ResultUsage{})
+
+		lib.JSON(w, http.StatusServiceUnavailable, types.Result{Code: 503, Message: "Job queue is full, try again later"})
+		return
+	}
+
+	fmt.Printf("--- Queued flow for #%s - %s\n\n", green(job.Id), green(request.Name))
+
+	lib.JSON(w, http.StatusAccepted, job.status())
+}
+
+/**
+ * Function to put the job into the queue, the job is forgotten when the queue is full
+ */
+func Enqueue(job *Job) bool {
+	select {
+	case jobQueue <- job:
+		return true
+	default:
+		jobsMutex.Lock()
+		delete(jobs, job.Id)
+		jobsMutex.Unlock()
+
+		return false
+	}
+}
+
+/**
+ * Function to cancel every job which is still waiting in the queue
+ */
+func CancelQueuedJobs() {
+	jobsMutex.RLock()
+	queued := make([]*Job, 0)
+
+	for _, job := range jobs {
+		if job.Status == types.JobQueued {
+			queued = append(queued, job)
+		}
+	}
+
+	jobsMutex.RUnlock()
+
+	for _, job := range queued {
+		job.Cancel()
+	}
+}
+
+/**
+ * Function to create a queued job and register it so the status can be polled
+ */
+func NewJob(request types.Config) *Job {
+	unique := uuid.New().String()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := &Job{
+		Job: types.Job{
+			Id:      unique[len(unique)-12:],
+			Name:    request.Name,
+			Status:  types.JobQueued,
+			Created: time.Now(),
+		},
+		request: request,
+		context: ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	jobsMutex.Lock()
+	jobs[job.Id] = job
+	jobsMutex.Unlock()
+
+	return job
+}
+
+func findJob(id string) *Job {
+	jobsMutex.RLock()
+	defer jobsMutex.RUnlock()
+
+	return jobs[id]
+}
+
+func (job *Job) status() types.Job {
+	jobsMutex.RLock()
+	defer jobsMutex.RUnlock()
+
+	return job.Job
+}
+
+func (job *Job) finalResult() *types.Result {
+	jobsMutex.RLock()
+	defer jobsMutex.RUnlock()
+
+	return job.result
+}
+
+/**
+ * Function to cancel the job, a queued job is finished immediately while a running
+ * job stops on the next cancellation point and keeps the partial result
+ */
+func (job *Job) Cancel() bool {
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	switch job.Status {
+	case types.JobQueued:
+		finished := time.Now()
+
+		job.Status = types.JobCancelled
+		job.Message = "The flow was cancelled before it started"
+		job.Finished = &finished
+		job.result = &types.Result{
+			Id:        job.Id,
+			Code:      499,
+			Name:      job.request.Name,
+			Message:   job.Message,
+			Cancelled: true,
+		}
+
+		close(job.done)
+
+		job.allowance.Release(types.ResultUsage{})
+
+		// The job never started, its run is kept in the history with the cancelled status
+		session := NewSession(job.context, job.request, job.Id)
+		session.Owner = job.owner
+
+		result := *job.result
+
+		// The run is saved before the webhook so the delivery log is added into it
+		go func() {
+			Record(session, result, job.Created, finished)
+			job.callback(result)
+		}()
+	case types.JobRunning:
+		job.Message = "Cancelling the running flow"
+	default:
+		return false
+	}
+
+	job.cancel()
+
+	log.Printf("%s Job #%s cancellation requested", yellow("[ Engine ]"), job.Id)
+
+	return true
+}
+
+func (job *Job) callback(result types.Result) {
+	deliveries := Callback(job.request, result)
+
+	if len(deliveries) == 0 {
+		return
+	}
+
+	jobsMutex.Lock()
+	job.Deliveries = append(job.Deliveries, deliveries...)
+	jobsMutex.Unlock()
+
+	RecordDeliveries(job.Id, deliveries)
+}
+
+func (job *Job) run() {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	defer job.cancel()
+
+	started := time.Now()
+
+	session := NewSession(job.context, job.request, job.Id)
+	session.Owner = job.owner
+	session.OnStep = job.publish
+
+	// The job is taken from the queue after the shutdown is started
+	if !Track(session) {
+		job.Cancel()
+		return
+	}
+
+	defer Untrack(session)
+
+	jobsMutex.Lock()
+
+	if job.Status != types.JobQueued {
+		jobsMutex.Unlock()
+		return
+	}
+
+	job.Status = types.JobRunning
+	job.Started = &started
+	jobsMutex.Unlock()
+
+	var result types.Result
+
+	job.allowance.Watch(session)
+
+	errorRun := rod.Try(func() {
+		result = Execute(session)
+	})
+
+	// Usage of the session is counted even when the flow crashed before it returned the result
+	job.allowance.Release(session.Usage())
+
+	abortCode, _ := session.Aborted()
+
+	if errorRun != nil && (job.context.Err() != nil || abortCode != "") {
+		result = Interrupted(session, Partial(session, types.Result{
+			Id:   job.Id,
+			Name: job.request.Name,
+		}))
+	} else if errorRun != nil {
+		log.Printf(red("[ Engine ] Job #%s crashed, due to %v"), job.Id, errorRun)
+
+		result = Partial(session, types.Result{
+			Id:      job.Id,
+			Code:    500,
+			Name:    job.request.Name,
+			Message: "Failed to run Flow due some error on our Engine",
+		})
+		result.Errors = append(result.Errors, errorRun.Error())
+	}
+
+	finished := time.Now()
+
+	jobsMutex.Lock()
+	job.Finished = &finished
+	job.Message = result.Message
+	job.result = &result
+
+	job.Status = ResultStatus(result)
+
+	close(job.done)
+	jobsMutex.Unlock()
+
+	// Counted here so the flow which crashed or was interrupted by a panic is counted as well
+	metricFlowsFinished.WithLabelValues(ResultStatus(result)).Inc()
+
+	Record(session, result, job.Created, started)
+
+	go job.callback(result)
+
+	log.Printf("%s Job #%s %s\n\n", yellow("[ Engine ]"), job.Id, ResultStatus(result))
+}
+
+/**
+ * Stream the step events of the job as server-sent events until the job is finished,
+ * already published events are replayed first so late subscribers see the whole run
+ */
+func JobEvents(w http.ResponseWriter, r *http.Request, job *Job) {
+	flusher, isFlusher := w.(http.Flusher)
+
+	if !isFlusher {
+		lib.JSON(w, http.StatusInternalServerError, types.Result{Code: 500, Message: "Streaming is not supported by the connection"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	cursor := 0
+
+	// Resume from the last received event when the client reconnects
+	if lastEventId, errorConvert := strconv.Atoi(r.Header.Get("Last-Event-ID")); errorConvert == nil {
+		cursor = lastEventId + 1
+	}
+
+	notify := job.subscribe()
+	defer job.unsubscribe(notify)
+
+	for {
+		events, finished := job.eventsFrom(cursor)
+
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: step\ndata: %s\n\n", cursor, data)
+			cursor++
+		}
+
+		if finished {
+			data, _ := json.Marshal(job.status())
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-notify:
+		case <-job.done:
+		}
+	}
+}
+
+func (job *Job) publish(event types.StepEvent) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	job.events = append(job.events, event)
+
+	for _, notify := range job.notify {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (job *Job) subscribe() chan struct{} {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	notify := make(chan struct{}, 1)
+	job.notify = append(job.notify, notify)
+
+	return notify
+}
+
+func (job *Job) unsubscribe(notify chan struct{}) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	for index, subscriber := range job.notify {
+		if subscriber == notify {
+			job.notify = append(job.notify[:index], job.notify[index+1:]...)
+			break
+		}
+	}
+}
+
+func (job *Job) eventsFrom(cursor int) ([]types.StepEvent, bool) {
+	jobsMutex.RLock()
+	defer jobsMutex.RUnlock()
+
+	finished := job.Finished != nil
+
+	if cursor >= len(job.events) {
+		return nil, finished
+	}
+
+	return append([]types.StepEvent(nil), job.events[cursor:]...), finished
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Returns the query of the artifact URL, the URL is valid until the expiry
+func SignArtifact(secret []byte, key string, expires time.Time) string {
+	unix := strconv.FormatInt(expires.Unix(), 10)
+
+	return "expires=" + unix + "&signature=" + artifactSignature(secret, key, unix)
+}
+
+// Checks the signature of the artifact URL and whether it is not expired yet
+func VerifyArtifact(secret []byte, key string, expires string, signature string) bool {
+	unix, errorExpires := strconv.ParseInt(expires, 10, 64)
+
+	if errorExpires != nil || time.Now().Unix() > unix {
+		return false
+	}
+
+	return hmac.Equal([]byte(artifactSignature(secret, key, expires)), []byte(signature))
+}
+
+func artifactSignature(secret []byte, key string, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(key + "\n" + expires))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
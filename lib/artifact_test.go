@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerifyArtifact(t *testing.T) {
+	secret := []byte("secret")
+
+	valid, _ := url.ParseQuery(SignArtifact(secret, "images/a.png", time.Now().Add(time.Hour)))
+	expired, _ := url.ParseQuery(SignArtifact(secret, "images/a.png", time.Now().Add(-time.Second)))
+
+	tests := []struct {
+		name      string
+		secret    []byte
+		key       string
+		expires   string
+		signature string
+		want      bool
+	}{
+		{"signed link", secret, "images/a.png", valid.Get("expires"), valid.Get("signature"), true},
+		{"expired link", secret, "images/a.png", expired.Get("expires"), expired.Get("signature"), false},
+		{"other key", secret, "images/b.png", valid.Get("expires"), valid.Get("signature"), false},
+		{"other secret", []byte("other"), "images/a.png", valid.Get("expires"), valid.Get("signature"), false},
+		{"extended expiry", secret, "images/a.png", "99999999999", valid.Get("signature"), false},
+		{"invalid expiry", secret, "images/a.png", "tomorrow", valid.Get("signature"), false},
+		{"missing signature", secret, "images/a.png", valid.Get("expires"), "", false},
+	}
+
+	for _, test := range tests {
+		if got := VerifyArtifact(test.secret, test.key, test.expires, test.signature); got != test.want {
+			t.Errorf("%s: want %v have %v", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"engine/types"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Reads the flow file and parses the YAML into config struct, the includes and
+// steps blocks are resolved relative to the flow file and only files inside its
+// directory may be included
+func ReadConfig(filename string) (*types.Config, error) {
+	buf, errorRead := ioutil.ReadFile(filename)
+
+	if errorRead != nil {
+		return nil, errorRead
+	}
+
+	config := &types.Config{}
+	errorUnmarshal := yaml.Unmarshal(buf, config)
+
+	if errorUnmarshal != nil {
+		return nil, errorUnmarshal
+	}
+
+	if errorResolve := Resolve(config, filename, filepath.Dir(filename), filepath.Dir(filename)); errorResolve != nil {
+		return nil, errorResolve
+	}
+
+	return config, nil
+}
+
+// Reads the API keys file used to authenticate the engine clients
+func ReadKeys(filename string) ([]types.ApiKey, error) {
+	buf, errorRead := ioutil.ReadFile(filename)
+
+	if errorRead != nil {
+		return nil, errorRead
+	}
+
+	config := &types.KeysConfig{}
+	errorUnmarshal := yaml.Unmarshal(buf, config)
+
+	if errorUnmarshal != nil {
+		return nil, errorUnmarshal
+	}
+
+	for index, key := range config.Keys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("key #%d %s has empty key", index+1, key.Name)
+		}
+	}
+
+	return config.Keys, nil
+}
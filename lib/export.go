@@ -0,0 +1,432 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"engine/types"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gosimple/slug"
+)
+
+var replacerSheet = strings.NewReplacer(`[`, ``, `]`, ``, `:`, ``, `*`, ``, `?`, ``, `/`, ``, `\`, ``)
+
+// Checks whether the output format is supported, empty output means JSON
+func ValidOutput(output string) bool {
+	switch output {
+	case "", types.OutputJson, types.OutputCsv, types.OutputNdjson, types.OutputXlsx:
+		return true
+	}
+
+	return false
+}
+
+// Returns the flat sheets of the result, records of every page come first
+// followed by one sheet for every table step
+func Sheets(result types.Result) []types.ResultSheet {
+	var sheets []types.ResultSheet
+
+	records := types.ResultSheet{Name: "records"}
+	columns := make(map[string]int)
+
+	tables := make(map[string]int)
+	items := result.Records
+
+	if len(result.Result) > 0 {
+		items = make([]types.ResultItem, 0, len(result.Result))
+
+		for _, page := range result.Result {
+			items = append(items, Records(page.Content)...)
+		}
+	}
+
+	for _, item := range items {
+		var row []string
+
+		for _, name := range item.Names {
+			value := item.Values[name]
+
+			if table, isTable := value.(*types.ResultTable); isTable {
+				tables[table.Name] = merge(&sheets, tables, *table)
+				continue
+			}
+
+			index, exists := columns[name]
+
+			if !exists {
+				index = len(records.Columns)
+				columns[name] = index
+				records.Columns = append(records.Columns, name)
+			}
+
+			for len(row) <= index {
+				row = append(row, "")
+			}
+
+			row[index] = cell(value)
+		}
+
+		if row != nil {
+			records.Rows = append(records.Rows, row)
+		}
+	}
+
+	if len(records.Rows) > 0 {
+		sheets = append([]types.ResultSheet{records}, sheets...)
+	}
+
+	// Every row has the same number of columns as the header, the column
+	// can be found after the earlier rows are added
+	for _, sheet := range sheets {
+		for index := range sheet.Rows {
+			for len(sheet.Rows[index]) < len(sheet.Columns) {
+				sheet.Rows[index] = append(sheet.Rows[index], "")
+			}
+		}
+	}
+
+	return sheets
+}
+
+// Returns the value of the record as spreadsheet cell, list and object are written as JSON
+func cell(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	}
+
+	encoded, _ := json.Marshal(value)
+
+	return string(encoded)
+}
+
+// Appends the rows of the table into the sheet with the same name, the same
+// table step runs on every paginated page
+func merge(sheets *[]types.ResultSheet, tables map[string]int, table types.ResultTable) int {
+	position, exists := tables[table.Name]
+
+	if !exists {
+		sheet := types.ResultSheet{Name: table.Name}
+
+		for _, header := range table.Header {
+			sheet.Columns = append(sheet.Columns, header.Content)
+		}
+
+		*sheets = append(*sheets, sheet)
+		position = len(*sheets) - 1
+	}
+
+	sheet := &(*sheets)[position]
+
+	for _, data := range table.Data {
+		row := make([]string, len(sheet.Columns))
+
+		for _, cell := range data {
+			column := -1
+
+			for index, name := range sheet.Columns {
+				if name == cell.Name {
+					column = index
+					break
+				}
+			}
+
+			if column == -1 {
+				sheet.Columns = append(sheet.Columns, cell.Name)
+				row = append(row, "")
+				column = len(sheet.Columns) - 1
+			}
+
+			row[column] = cell.Content
+		}
+
+		sheet.Rows = append(sheet.Rows, row)
+	}
+
+	return position
+}
+
+// Writes the result in the requested output format, JSON is written the same way as Response
+func Export(w http.ResponseWriter, data types.Result, pageId string, output string, legacy bool) {
+	if output == "" || output == types.OutputJson || data.Code != 200 {
+		Response(w, data, pageId, legacy)
+		return
+	}
+
+	var buffer bytes.Buffer
+	var errorExport error
+
+	sheets := Sheets(data)
+	filename := data.Slug
+
+	if filename == "" {
+		filename = "result"
+	}
+
+	switch output {
+	case types.OutputCsv:
+		if len(sheets) <= 1 {
+			sheets = append(sheets, types.ResultSheet{Name: "records"})
+
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			filename += ".csv"
+			errorExport = WriteCsv(&buffer, sheets[0])
+		} else {
+			w.Header().Set("Content-Type", "application/zip")
+			filename += ".zip"
+			errorExport = WriteCsvArchive(&buffer, filename, sheets)
+		}
+	case types.OutputNdjson:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		filename += ".ndjson"
+		errorExport = WriteNdjson(&buffer, sheets)
+	case types.OutputXlsx:
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		filename += ".xlsx"
+		errorExport = WriteXlsx(&buffer, sheets)
+	}
+
+	if errorExport != nil {
+		w.Header().Del("Content-Type")
+		JSON(w, http.StatusInternalServerError, types.Result{Code: 500, Message: "Failed to export the result, " + errorExport.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buffer.Bytes())
+}
+
+// Writes the sheet as CSV with the columns as header, the scraped cell which a
+// spreadsheet would run as a formula is written as text
+func WriteCsv(w io.Writer, sheet types.ResultSheet) error {
+	writer := csv.NewWriter(w)
+
+	for _, row := range append([][]string{sheet.Columns}, sheet.Rows...) {
+		cells := make([]string, len(row))
+
+		for index, value := range row {
+			cells[index] = text(value)
+		}
+
+		if errorWrite := writer.Write(cells); errorWrite != nil {
+			return errorWrite
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// Returns the cell prefixed with a quote when it starts like a formula
+func text(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// Writes every sheet as CSV file inside the zip archive
+func WriteCsvArchive(w io.Writer, name string, sheets []types.ResultSheet) error {
+	archive := zip.NewWriter(w)
+
+	for _, sheet := range sheets {
+		file, errorCreate := archive.Create(SheetFile(name, sheet, "csv"))
+
+		if errorCreate != nil {
+			return errorCreate
+		}
+
+		errorWrite := WriteCsv(file, sheet)
+
+		if errorWrite != nil {
+			return errorWrite
+		}
+	}
+
+	return archive.Close()
+}
+
+// Returns the file name of the sheet, records keep the name of the result
+func SheetFile(name string, sheet types.ResultSheet, extension string) string {
+	name = strings.TrimSuffix(name, ".zip")
+
+	if sheet.Name == "records" {
+		return name + "." + extension
+	}
+
+	return name + "-" + slug.Make(sheet.Name) + "." + extension
+}
+
+// Writes every row as a JSON object, rows of table step carry the table name
+func WriteNdjson(w io.Writer, sheets []types.ResultSheet) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	for _, sheet := range sheets {
+		for _, row := range sheet.Rows {
+			record := make(map[string]string, len(sheet.Columns)+1)
+
+			if sheet.Name != "records" {
+				record["_table"] = sheet.Name
+			}
+
+			for index, column := range sheet.Columns {
+				record[column] = row[index]
+			}
+
+			errorEncode := encoder.Encode(record)
+
+			if errorEncode != nil {
+				return errorEncode
+			}
+		}
+	}
+
+	return nil
+}
+
+// Writes the workbook with one worksheet for every sheet
+func WriteXlsx(w io.Writer, sheets []types.ResultSheet) error {
+	archive := zip.NewWriter(w)
+
+	if len(sheets) == 0 {
+		sheets = []types.ResultSheet{{Name: "records"}}
+	}
+
+	var contentTypes, workbook, relations strings.Builder
+
+	contentTypes.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	relations.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	used := make(map[string]bool)
+
+	for index, sheet := range sheets {
+		number := strconv.Itoa(index + 1)
+
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%s.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, number)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%s" r:id="rId%s"/>`, escape(sheetName(sheet.Name, index, used)), number, number)
+		fmt.Fprintf(&relations, `<Relationship Id="rId%s" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%s.xml"/>`, number, number)
+
+		errorSheet := writeZipFile(archive, "xl/worksheets/sheet"+number+".xml", worksheet(sheet))
+
+		if errorSheet != nil {
+			return errorSheet
+		}
+	}
+
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	relations.WriteString(`</Relationships>`)
+
+	files := [][2]string{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?><Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", relations.String()},
+	}
+
+	for _, file := range files {
+		errorFile := writeZipFile(archive, file[0], file[1])
+
+		if errorFile != nil {
+			return errorFile
+		}
+	}
+
+	return archive.Close()
+}
+
+func worksheet(sheet types.ResultSheet) string {
+	var builder strings.Builder
+
+	builder.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	rows := append([][]string{sheet.Columns}, sheet.Rows...)
+
+	for rowIndex, row := range rows {
+		fmt.Fprintf(&builder, `<row r="%d">`, rowIndex+1)
+
+		for columnIndex, value := range row {
+			fmt.Fprintf(&builder, `<c r="%s%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, column(columnIndex), rowIndex+1, escape(value))
+		}
+
+		builder.WriteString(`</row>`)
+	}
+
+	builder.WriteString(`</sheetData></worksheet>`)
+
+	return builder.String()
+}
+
+// Returns the unique worksheet name, Excel allows 31 characters without []:*?/\
+func sheetName(name string, index int, used map[string]bool) string {
+	name = strings.TrimSpace(replacerSheet.Replace(name))
+
+	if name == "" {
+		name = "Sheet" + strconv.Itoa(index+1)
+	}
+
+	if len([]rune(name)) > 31 {
+		name = string([]rune(name)[:31])
+	}
+
+	for suffix := 2; used[strings.ToLower(name)]; suffix++ {
+		tail := "-" + strconv.Itoa(suffix)
+		runes := []rune(name)
+
+		if len(runes)+len(tail) > 31 {
+			runes = runes[:31-len(tail)]
+		}
+
+		name = string(runes) + tail
+	}
+
+	used[strings.ToLower(name)] = true
+
+	return name
+}
+
+// Returns the column letter of the spreadsheet, 0 is A and 26 is AA
+func column(index int) string {
+	name := ""
+
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+
+	return name
+}
+
+func escape(value string) string {
+	var buffer bytes.Buffer
+
+	xml.EscapeText(&buffer, []byte(value))
+
+	return buffer.String()
+}
+
+func writeZipFile(archive *zip.Writer, name string, content string) error {
+	file, errorCreate := archive.Create(name)
+
+	if errorCreate != nil {
+		return errorCreate
+	}
+
+	_, errorWrite := file.Write([]byte(content))
+
+	return errorWrite
+}
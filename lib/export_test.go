@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"engine/types"
+	"io"
+	"strings"
+	"testing"
+)
+
+func result() types.Result {
+	table := &types.ResultTable{
+		Name:   "specs",
+		Header: []types.ResultTableHead{{Content: "key"}, {Content: "value"}},
+		Data: [][]types.ResultTableData{
+			{{Name: "key", Content: "weight"}, {Name: "value", Content: "1kg"}},
+			{{Name: "key", Content: "color"}, {Name: "value", Content: "red"}},
+		},
+	}
+
+	return types.Result{
+		Code: 200,
+		Records: []types.ResultItem{
+			{Names: []string{"name", "price"}, Values: map[string]interface{}{"name": "Shoes", "price": "10"}},
+			{Names: []string{"name", "tags", "specs"}, Values: map[string]interface{}{"name": "=HYPERLINK(\"x\")", "tags": []interface{}{"a", "b"}, "specs": table}},
+		},
+	}
+}
+
+func TestSheets(t *testing.T) {
+	sheets := Sheets(result())
+
+	if len(sheets) != 2 {
+		t.Fatalf("want records and table sheets have %d", len(sheets))
+	}
+
+	records := sheets[0]
+
+	if records.Name != "records" || strings.Join(records.Columns, ",") != "name,price,tags" {
+		t.Errorf("want records columns name,price,tags have %s %v", records.Name, records.Columns)
+	}
+
+	// Earlier row is filled up to the columns found in the later rows
+	if len(records.Rows) != 2 || len(records.Rows[0]) != 3 || records.Rows[1][2] != `["a","b"]` {
+		t.Errorf("want two full rows with the list as JSON have %q", records.Rows)
+	}
+
+	if sheets[1].Name != "specs" || len(sheets[1].Rows) != 2 || sheets[1].Rows[1][1] != "red" {
+		t.Errorf("want the table sheet have %+v", sheets[1])
+	}
+}
+
+func TestWriteCsv(t *testing.T) {
+	sheet := types.ResultSheet{
+		Name:    "records",
+		Columns: []string{"name", "note"},
+		Rows: [][]string{
+			{"Shoes", "plain, with comma"},
+			{"=1+1", "+cmd"},
+			{"-2", "@SUM(A1)"},
+			{"a=b", "line\nbreak"},
+		},
+	}
+
+	var buffer bytes.Buffer
+
+	if errorWrite := WriteCsv(&buffer, sheet); errorWrite != nil {
+		t.Fatal(errorWrite)
+	}
+
+	rows, errorRead := csv.NewReader(&buffer).ReadAll()
+
+	if errorRead != nil {
+		t.Fatal(errorRead)
+	}
+
+	want := [][]string{
+		{"name", "note"},
+		{"Shoes", "plain, with comma"},
+		{"'=1+1", "'+cmd"},
+		{"'-2", "'@SUM(A1)"},
+		{"a=b", "line\nbreak"},
+	}
+
+	if len(rows) != len(want) {
+		t.Fatalf("want %d rows have %d", len(want), len(rows))
+	}
+
+	for index := range want {
+		if strings.Join(rows[index], "|") != strings.Join(want[index], "|") {
+			t.Errorf("row %d: want %q have %q", index, want[index], rows[index])
+		}
+	}
+}
+
+func TestWriteNdjson(t *testing.T) {
+	var buffer bytes.Buffer
+
+	if errorWrite := WriteNdjson(&buffer, Sheets(result())); errorWrite != nil {
+		t.Fatal(errorWrite)
+	}
+
+	var lines []map[string]string
+
+	scanner := bufio.NewScanner(&buffer)
+
+	for scanner.Scan() {
+		line := make(map[string]string)
+
+		if errorDecode := json.Unmarshal(scanner.Bytes(), &line); errorDecode != nil {
+			t.Fatalf("want JSON line have %q, %v", scanner.Text(), errorDecode)
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 4 {
+		t.Fatalf("want 4 lines have %d", len(lines))
+	}
+
+	// JSON keeps the scraped value as it is, only CSV is opened as a spreadsheet
+	if lines[1]["name"] != `=HYPERLINK("x")` || lines[0]["_table"] != "" {
+		t.Errorf("want the record line have %v", lines[1])
+	}
+
+	if lines[3]["_table"] != "specs" || lines[3]["key"] != "color" {
+		t.Errorf("want the table line have %v", lines[3])
+	}
+}
+
+func TestWriteXlsx(t *testing.T) {
+	var buffer bytes.Buffer
+
+	if errorWrite := WriteXlsx(&buffer, Sheets(result())); errorWrite != nil {
+		t.Fatal(errorWrite)
+	}
+
+	archive, errorZip := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+
+	if errorZip != nil {
+		t.Fatal(errorZip)
+	}
+
+	files := make(map[string]bool)
+
+	for _, file := range archive.File {
+		files[file.Name] = true
+
+		if !strings.HasSuffix(file.Name, ".xml") && !strings.HasSuffix(file.Name, ".rels") {
+			continue
+		}
+
+		reader, errorOpen := file.Open()
+
+		if errorOpen != nil {
+			t.Fatal(errorOpen)
+		}
+
+		decoder := xml.NewDecoder(reader)
+
+		for {
+			_, errorToken := decoder.Token()
+
+			if errorToken == io.EOF {
+				break
+			}
+
+			if errorToken != nil {
+				t.Errorf("%s: want valid XML have %v", file.Name, errorToken)
+				break
+			}
+		}
+
+		reader.Close()
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml"} {
+		if !files[name] {
+			t.Errorf("want %s in the workbook", name)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package lib
+
+import (
+	"encoding/json"
+	"engine/types"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Named block of steps with the file it is written in, the includes of the
+// block are read relative to that file
+type block struct {
+	steps     types.Steps
+	file      string
+	directory string
+}
+
+type resolver struct {
+	root  string
+	stack []string
+}
+
+// Resolves the include and use steps of the flow into the steps they refer to, the
+// included file is read relative to the directory and must be inside the root,
+// every include is refused when the root is empty
+func Resolve(config *types.Config, file string, directory string, root string) error {
+	resolver := &resolver{root: root, stack: []string{filepath.Clean(file)}}
+	blocks := resolver.blocks(nil, config.Steps, file, directory)
+
+	flow, errorResolve := resolver.flow(config.Flow, blocks, file, directory, "")
+
+	if errorResolve != nil {
+		return errorResolve
+	}
+
+	config.Flow = flow
+	config.Steps = nil
+
+	return nil
+}
+
+// Returns the blocks of the file on top of the blocks of the file which includes it
+func (resolver *resolver) blocks(parent map[string]block, steps map[string]types.Steps, file string, directory string) map[string]block {
+	blocks := make(map[string]block, len(parent)+len(steps))
+
+	for name, value := range parent {
+		blocks[name] = value
+	}
+
+	for name, value := range steps {
+		blocks[name] = block{steps: value, file: file, directory: directory}
+	}
+
+	return blocks
+}
+
+func (resolver *resolver) flow(flow []types.Flow, blocks map[string]block, file string, directory string, prefix string) ([]types.Flow, error) {
+	resolved := make([]types.Flow, 0, len(flow))
+
+	for index, step := range flow {
+		path := prefix + strconv.Itoa(index)
+
+		switch {
+		case step.Include != "":
+			steps, errorInclude := resolver.include(step, blocks, directory)
+
+			if errorInclude != nil {
+				return nil, fmt.Errorf("%s step %s: include %s: %w", file, path, step.Include, errorInclude)
+			}
+
+			resolved = append(resolved, steps...)
+		case step.Use != "":
+			steps, errorUse := resolver.use(step, blocks)
+
+			if errorUse != nil {
+				return nil, fmt.Errorf("%s step %s: use %s: %w", file, path, step.Use, errorUse)
+			}
+
+			resolved = append(resolved, steps...)
+		default:
+			nested := []struct {
+				steps *[]types.Flow
+				name  string
+			}{
+				{&step.If.Then, ".then."},
+				{&step.If.Else, ".else."},
+				{&step.ForEach.Steps, ".for_each."},
+				{&step.Follow.Steps, ".follow."},
+			}
+
+			for _, list := range nested {
+				if len(*list.steps) == 0 {
+					continue
+				}
+
+				steps, errorNested := resolver.flow(*list.steps, blocks, file, directory, path+list.name)
+
+				if errorNested != nil {
+					return nil, errorNested
+				}
+
+				*list.steps = steps
+			}
+
+			resolved = append(resolved, step)
+		}
+	}
+
+	return resolved, nil
+}
+
+func (resolver *resolver) include(step types.Flow, blocks map[string]block, directory string) ([]types.Flow, error) {
+	file := step.Include
+
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(directory, file)
+	}
+
+	file = filepath.Clean(file)
+
+	if resolver.root == "" {
+		return nil, errors.New("include is disabled without the includes directory")
+	}
+
+	if !resolver.inside(file) {
+		return nil, errors.New("file is outside of the includes directory")
+	}
+
+	if errorCycle := resolver.push(file); errorCycle != nil {
+		return nil, errorCycle
+	}
+
+	defer resolver.pop()
+
+	// The content of the file is never part of the error, the error may be sent to the client
+	buf, errorRead := ioutil.ReadFile(file)
+
+	if errorRead != nil {
+		return nil, errors.New("file cannot be read")
+	}
+
+	included := &types.Config{}
+
+	if yaml.Unmarshal(buf, included) != nil {
+		return nil, errors.New("file is not a valid flow file")
+	}
+
+	flow, errorRender := render(included.Flow, step.With)
+
+	if errorRender != nil {
+		return nil, fmt.Errorf("%s: %w", file, errorRender)
+	}
+
+	return resolver.flow(flow, resolver.blocks(blocks, included.Steps, file, filepath.Dir(file)), file, filepath.Dir(file), "")
+}
+
+// Checks whether the file is inside the root after the symbolic links are followed,
+// the missing file is checked by its directory so it is reported as unreadable
+func (resolver *resolver) inside(file string) bool {
+	root, errorRoot := filepath.EvalSymlinks(resolver.root)
+	target, errorTarget := filepath.EvalSymlinks(file)
+
+	if os.IsNotExist(errorTarget) {
+		target, errorTarget = filepath.EvalSymlinks(filepath.Dir(file))
+		target = filepath.Join(target, filepath.Base(file))
+	}
+
+	if errorRoot != nil || errorTarget != nil {
+		return false
+	}
+
+	root, _ = filepath.Abs(root)
+	target, _ = filepath.Abs(target)
+
+	return strings.HasPrefix(target, root+string(filepath.Separator))
+}
+
+func (resolver *resolver) use(step types.Flow, blocks map[string]block) ([]types.Flow, error) {
+	named, exists := blocks[step.Use]
+
+	if !exists {
+		return nil, errors.New("steps block is not defined")
+	}
+
+	for name := range step.With {
+		if !Contains(named.steps.Params, name) {
+			return nil, fmt.Errorf("param %s is not defined", name)
+		}
+	}
+
+	for _, name := range named.steps.Params {
+		if _, given := step.With[name]; !given {
+			return nil, fmt.Errorf("param %s is missing", name)
+		}
+	}
+
+	if errorCycle := resolver.push("steps " + step.Use); errorCycle != nil {
+		return nil, errorCycle
+	}
+
+	defer resolver.pop()
+
+	flow, errorRender := render(named.steps.Flow, step.With)
+
+	if errorRender != nil {
+		return nil, errorRender
+	}
+
+	return resolver.flow(flow, blocks, named.file+" steps "+step.Use, named.directory, "")
+}
+
+func (resolver *resolver) push(name string) error {
+	for _, previous := range resolver.stack {
+		if previous == name {
+			return fmt.Errorf("cycle %s -> %s", strings.Join(resolver.stack, " -> "), name)
+		}
+	}
+
+	resolver.stack = append(resolver.stack, name)
+
+	return nil
+}
+
+func (resolver *resolver) pop() {
+	resolver.stack = resolver.stack[:len(resolver.stack)-1]
+}
+
+// Returns the copy of the steps with the params in the templates, the template
+// of any other name is kept for the flow variables
+func render(flow []types.Flow, params map[string]string) ([]types.Flow, error) {
+	encoded, errorEncode := json.Marshal(flow)
+
+	if errorEncode != nil {
+		return nil, errorEncode
+	}
+
+	var tree interface{}
+
+	if errorDecode := json.Unmarshal(encoded, &tree); errorDecode != nil {
+		return nil, errorDecode
+	}
+
+	lookup := func(name string) (string, bool) {
+		value, exists := params[name]
+
+		return value, exists
+	}
+
+	encoded, errorEncode = json.Marshal(renderTree(tree, lookup))
+
+	if errorEncode != nil {
+		return nil, errorEncode
+	}
+
+	var rendered []types.Flow
+
+	errorDecode := json.Unmarshal(encoded, &rendered)
+
+	return rendered, errorDecode
+}
+
+func renderTree(node interface{}, lookup func(name string) (string, bool)) interface{} {
+	switch typed := node.(type) {
+	case string:
+		return Render(typed, lookup)
+	case []interface{}:
+		for index, value := range typed {
+			typed[index] = renderTree(value, lookup)
+		}
+	case map[string]interface{}:
+		for key, value := range typed {
+			typed[key] = renderTree(value, lookup)
+		}
+	}
+
+	return node
+}
@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"engine/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Writes the files into the temporary directory and returns the directory
+func includes(t *testing.T, files map[string]string) string {
+	directory := t.TempDir()
+
+	for name, content := range files {
+		file := filepath.Join(directory, name)
+
+		if errorDirectory := os.MkdirAll(filepath.Dir(file), 0755); errorDirectory != nil {
+			t.Fatal(errorDirectory)
+		}
+
+		if errorWrite := ioutil.WriteFile(file, []byte(content), 0644); errorWrite != nil {
+			t.Fatal(errorWrite)
+		}
+	}
+
+	return directory
+}
+
+func flow(t *testing.T, content string) *types.Config {
+	config := &types.Config{}
+
+	if errorYaml := yaml.Unmarshal([]byte(content), config); errorYaml != nil {
+		t.Fatal(errorYaml)
+	}
+
+	return config
+}
+
+func TestResolve(t *testing.T) {
+	directory := includes(t, map[string]string{
+		"login.yml": `
+flow:
+  - element:
+      selector: "#user"
+      write: "{{ user }}"
+  - element:
+      selector: "#query"
+      write: "{{ query }}"
+`,
+	})
+
+	config := flow(t, `
+steps:
+  title:
+    params: [selector]
+    flow:
+      - take:
+          name: title
+          selector: "{{ selector }}"
+flow:
+  - include: login.yml
+    with:
+      user: owl
+  - if:
+      exists: .product
+      then:
+        - use: title
+          with:
+            selector: h1
+`)
+
+	if errorResolve := Resolve(config, filepath.Join(directory, "flow.yml"), directory, directory); errorResolve != nil {
+		t.Fatal(errorResolve)
+	}
+
+	if len(config.Flow) != 3 {
+		t.Fatalf("want 3 steps have %d", len(config.Flow))
+	}
+
+	if write := config.Flow[0].Element.Write; write != "owl" {
+		t.Errorf("want the param rendered have %q", write)
+	}
+
+	// Template which is not a param is kept for the flow variables
+	if write := config.Flow[1].Element.Write; write != "{{ query }}" {
+		t.Errorf("want the variable kept have %q", write)
+	}
+
+	if then := config.Flow[2].If.Then; len(then) != 1 || then[0].Take.Selector != "h1" {
+		t.Errorf("want the nested use resolved have %+v", then)
+	}
+
+	if config.Steps != nil {
+		t.Errorf("want the steps blocks removed have %+v", config.Steps)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	directory := includes(t, map[string]string{
+		"a.yml":       "flow:\n  - include: b.yml\n",
+		"b.yml":       "flow:\n  - delay: 1\n  - include: a.yml\n",
+		"invalid.yml": "flow: [secret content\n",
+	})
+
+	outside := includes(t, map[string]string{"outside.yml": "flow:\n  - delay: 1\n"})
+
+	tests := []struct {
+		name   string
+		flow   string
+		root   string
+		errors []string
+	}{
+		{
+			"include cycle",
+			"flow:\n  - include: a.yml\n",
+			directory,
+			[]string{"flow.yml step 0: include a.yml", "b.yml step 1: include a.yml", "cycle", "a.yml -> " + filepath.Join(directory, "b.yml")},
+		},
+		{
+			"nested step path",
+			"flow:\n  - delay: 1\n  - if:\n      exists: .a\n      then:\n        - include: missing.yml\n",
+			directory,
+			[]string{"flow.yml step 1.then.0: include missing.yml", "file cannot be read"},
+		},
+		{
+			"invalid file",
+			"flow:\n  - include: invalid.yml\n",
+			directory,
+			[]string{"step 0: include invalid.yml", "file is not a valid flow file"},
+		},
+		{
+			"outside of the root",
+			"flow:\n  - include: " + filepath.Join(outside, "outside.yml") + "\n",
+			directory,
+			[]string{"file is outside of the includes directory"},
+		},
+		{
+			"parent directory",
+			"flow:\n  - include: ../" + filepath.Base(outside) + "/outside.yml\n",
+			directory,
+			[]string{"file is outside of the includes directory"},
+		},
+		{
+			"disabled without root",
+			"flow:\n  - include: a.yml\n",
+			"",
+			[]string{"include is disabled without the includes directory"},
+		},
+		{
+			"unknown block",
+			"flow:\n  - use: missing\n",
+			directory,
+			[]string{"flow.yml step 0: use missing", "steps block is not defined"},
+		},
+		{
+			"unknown param",
+			"steps:\n  price:\n    params: [selector]\n    flow:\n      - delay: 1\nflow:\n  - use: price\n    with:\n      selector: .price\n      name: price\n",
+			directory,
+			[]string{"step 0: use price", "param name is not defined"},
+		},
+		{
+			"missing param",
+			"steps:\n  price:\n    params: [selector]\n    flow:\n      - delay: 1\nflow:\n  - delay: 1\n  - use: price\n",
+			directory,
+			[]string{"step 1: use price", "param selector is missing"},
+		},
+		{
+			"recursive block",
+			"steps:\n  loop:\n    flow:\n      - use: loop\nflow:\n  - use: loop\n",
+			directory,
+			[]string{"step 0: use loop", "cycle", "steps loop -> steps loop"},
+		},
+	}
+
+	for _, test := range tests {
+		errorResolve := Resolve(flow(t, test.flow), filepath.Join(directory, "flow.yml"), directory, test.root)
+
+		if errorResolve == nil {
+			t.Errorf("%s: want error", test.name)
+			continue
+		}
+
+		for _, want := range test.errors {
+			if !strings.Contains(errorResolve.Error(), want) {
+				t.Errorf("%s: want %q in %q", test.name, want, errorResolve.Error())
+			}
+		}
+
+		if strings.Contains(errorResolve.Error(), "secret content") {
+			t.Errorf("%s: want the file content hidden in %q", test.name, errorResolve.Error())
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"engine/types"
+)
+
+// Groups the content of every item into the page which is loaded for it and
+// returns the pages with the flat list of records from every page
+func Items(contents []types.ResultPage, itemsOnPage int) ([]types.ResultPageLoad, []types.ResultItem) {
+	var pages []types.ResultPageLoad
+	var records []types.ResultItem
+
+	if itemsOnPage < 1 {
+		itemsOnPage = 1
+	}
+
+	for _, content := range contents {
+		number := (content.Page-1)/itemsOnPage + 1
+
+		if len(pages) == 0 || pages[len(pages)-1].Page != number {
+			pages = append(pages, types.ResultPageLoad{
+				Page:  number,
+				Items: make([]types.ResultItem, 0, itemsOnPage),
+			})
+		}
+
+		page := &pages[len(pages)-1]
+
+		// Title and URL of the partial item are unknown when the flow is stopped
+		if page.Title == "" && page.Url == "" {
+			page.Title = content.Title
+			page.Url = content.Url
+		}
+
+		page.Duration += content.Duration
+
+		items := Records(content.Content)
+
+		page.Items = append(page.Items, items...)
+		records = append(records, items...)
+	}
+
+	return pages, records
+}
+
+// Returns the records of the content, every item of the nested for_each or follow step
+// becomes its own record which also carries the values taken outside of the loop, the
+// items of sibling loops are merged by their position instead of being multiplied and
+// the record without any taken value is skipped
+func Records(contents []types.ResultContent) []types.ResultItem {
+	var records []types.ResultItem
+
+	for _, record := range positions(contents) {
+		if len(record.Names) > 0 {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// Returns the record of every position, the empty record keeps the position of the
+// loop item without values so the items of the sibling loops stay aligned
+func positions(contents []types.ResultContent) []types.ResultItem {
+	var values []types.ResultContent
+	var loops [][]types.ResultItem
+
+	count := 1
+
+	for _, content := range contents {
+		if content.Type != "for_each" && content.Type != "follow" {
+			values = append(values, content)
+			continue
+		}
+
+		var loopRecords []types.ResultItem
+
+		for _, loopContent := range content.Items {
+			loopRecords = append(loopRecords, positions(loopContent)...)
+		}
+
+		if len(loopRecords) > count {
+			count = len(loopRecords)
+		}
+
+		loops = append(loops, loopRecords)
+	}
+
+	item := Item(values)
+	records := make([]types.ResultItem, count)
+
+	for index := range records {
+		records[index] = item
+
+		for _, loopRecords := range loops {
+			if index < len(loopRecords) {
+				records[index] = join(records[index], loopRecords[index])
+			}
+		}
+	}
+
+	return records
+}
+
+// Returns the record with the values of both records, the value of the loop wins
+func join(record types.ResultItem, loopRecord types.ResultItem) types.ResultItem {
+	joined := types.ResultItem{
+		Names:  append([]string(nil), record.Names...),
+		Values: make(map[string]interface{}, len(record.Values)+len(loopRecord.Values)),
+	}
+
+	for name, value := range record.Values {
+		joined.Values[name] = value
+	}
+
+	for _, name := range loopRecord.Names {
+		if _, exists := joined.Values[name]; !exists {
+			joined.Names = append(joined.Names, name)
+		}
+
+		joined.Values[name] = loopRecord.Values[name]
+	}
+
+	return joined
+}
+
+// Returns the item of the content, the same name taken more than once becomes a list
+func Item(contents []types.ResultContent) types.ResultItem {
+	item := types.ResultItem{Values: make(map[string]interface{})}
+
+	for _, content := range contents {
+		if content.Name == "" || content.Type == "for_each" || content.Type == "follow" {
+			continue
+		}
+
+		var value interface{} = content.Content
+
+		if content.Table != nil {
+			value = content.Table
+		}
+
+		previous, exists := item.Values[content.Name]
+
+		if !exists {
+			item.Names = append(item.Names, content.Name)
+			item.Values[content.Name] = value
+			continue
+		}
+
+		if list, isList := previous.([]interface{}); isList {
+			item.Values[content.Name] = append(list, value)
+		} else {
+			item.Values[content.Name] = []interface{}{previous, value}
+		}
+	}
+
+	return item
+}
@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"encoding/json"
+	"engine/types"
+	"testing"
+)
+
+func take(name string, content string) types.ResultContent {
+	return types.ResultContent{Type: "text", Length: len(content), Name: name, Content: content}
+}
+
+func loop(kind string, items ...[]types.ResultContent) types.ResultContent {
+	return types.ResultContent{Type: kind, Length: len(items), Items: items}
+}
+
+// Encodes the records in the order of the takes so the test compares the names as well
+func encode(t *testing.T, records []types.ResultItem) string {
+	encoded, errorEncode := json.Marshal(records)
+
+	if errorEncode != nil {
+		t.Fatal(errorEncode)
+	}
+
+	return string(encoded)
+}
+
+func TestRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents []types.ResultContent
+		want     string
+	}{
+		{
+			"flat takes",
+			[]types.ResultContent{take("title", "Shoes"), take("price", "10")},
+			`[{"title":"Shoes","price":"10"}]`,
+		},
+		{
+			"same name becomes a list",
+			[]types.ResultContent{take("tag", "red"), take("tag", "blue"), take("tag", "green")},
+			`[{"tag":["red","blue","green"]}]`,
+		},
+		{
+			"no takes",
+			[]types.ResultContent{{Type: "click"}, {Type: "delay"}},
+			`null`,
+		},
+		{
+			"loop carries the outer values",
+			[]types.ResultContent{
+				take("category", "Shoes"),
+				loop("for_each", []types.ResultContent{take("name", "A")}, []types.ResultContent{take("name", "B")}),
+			},
+			`[{"category":"Shoes","name":"A"},{"category":"Shoes","name":"B"}]`,
+		},
+		{
+			"loop value wins",
+			[]types.ResultContent{
+				take("name", "outer"),
+				loop("follow", []types.ResultContent{take("name", "inner")}),
+			},
+			`[{"name":"inner"}]`,
+		},
+		{
+			"sibling loops merged by position",
+			[]types.ResultContent{
+				loop("for_each", []types.ResultContent{take("name", "A")}, []types.ResultContent{take("name", "B")}, []types.ResultContent{take("name", "C")}),
+				loop("follow", []types.ResultContent{take("detail", "a")}, []types.ResultContent{take("detail", "b")}),
+			},
+			`[{"name":"A","detail":"a"},{"name":"B","detail":"b"},{"name":"C"}]`,
+		},
+		{
+			"empty loop item keeps the position",
+			[]types.ResultContent{
+				loop("for_each", []types.ResultContent{take("name", "A")}, []types.ResultContent{{Type: "click"}}, []types.ResultContent{take("name", "C")}),
+				loop("for_each", []types.ResultContent{take("price", "1")}, []types.ResultContent{take("price", "2")}, []types.ResultContent{take("price", "3")}),
+			},
+			`[{"name":"A","price":"1"},{"price":"2"},{"name":"C","price":"3"}]`,
+		},
+		{
+			"empty records skipped",
+			[]types.ResultContent{
+				loop("for_each", []types.ResultContent{{Type: "click"}}, []types.ResultContent{take("name", "B")}),
+			},
+			`[{"name":"B"}]`,
+		},
+		{
+			"nested loops",
+			[]types.ResultContent{
+				take("shop", "S"),
+				loop("for_each",
+					[]types.ResultContent{take("category", "X"), loop("for_each", []types.ResultContent{take("name", "A")}, []types.ResultContent{take("name", "B")})},
+					[]types.ResultContent{take("category", "Y"), loop("for_each", []types.ResultContent{take("name", "C")})},
+				),
+			},
+			`[{"shop":"S","category":"X","name":"A"},{"shop":"S","category":"X","name":"B"},{"shop":"S","category":"Y","name":"C"}]`,
+		},
+	}
+
+	for _, test := range tests {
+		if got := encode(t, Records(test.contents)); got != test.want {
+			t.Errorf("%s: want %s have %s", test.name, test.want, got)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	record := types.ResultItem{Names: []string{"a", "b"}, Values: map[string]interface{}{"a": "1", "b": "2"}}
+	loopRecord := types.ResultItem{Names: []string{"b", "c"}, Values: map[string]interface{}{"b": "loop", "c": "3"}}
+
+	joined := join(record, loopRecord)
+
+	if got, want := encode(t, []types.ResultItem{joined}), `[{"a":"1","b":"loop","c":"3"}]`; got != want {
+		t.Errorf("want %s have %s", want, got)
+	}
+
+	// Joined record is a copy, the record of the outer values is shared by every loop item
+	if got, want := encode(t, []types.ResultItem{record}), `[{"a":"1","b":"2"}]`; got != want {
+		t.Errorf("want the record unchanged %s have %s", want, got)
+	}
+}
+
+func TestItems(t *testing.T) {
+	contents := []types.ResultPage{
+		{Title: "Page 1", Url: "/1", Page: 1, Duration: 10, Content: []types.ResultContent{take("name", "A")}},
+		{Title: "Page 1", Url: "/1", Page: 2, Duration: 20, Content: []types.ResultContent{{Type: "click"}}},
+		{Title: "Page 2", Url: "/2", Page: 3, Duration: 30, Content: []types.ResultContent{take("name", "C")}},
+	}
+
+	pages, records := Items(contents, 2)
+
+	if len(pages) != 2 {
+		t.Fatalf("want 2 pages have %d", len(pages))
+	}
+
+	if pages[0].Title != "Page 1" || pages[0].Duration != 30 || encode(t, pages[0].Items) != `[{"name":"A"}]` {
+		t.Errorf("want the first two items on the first page have %+v", pages[0])
+	}
+
+	if pages[1].Page != 2 || pages[1].Url != "/2" || encode(t, pages[1].Items) != `[{"name":"C"}]` {
+		t.Errorf("want the third item on the second page have %+v", pages[1])
+	}
+
+	if got, want := encode(t, records), `[{"name":"A"},{"name":"C"}]`; got != want {
+		t.Errorf("want %s have %s", want, got)
+	}
+}
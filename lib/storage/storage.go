@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"engine/lib"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Storage keeps the artifacts of the flow, captured images and recordings,
+// every artifact is addressed by the key relative to the resources directory
+type Storage interface {
+	// Name of the backend for the logs and the health check
+	Name() string
+
+	// Stores the local file under the key and returns its URL
+	Put(ctx context.Context, key string, path string) (string, error)
+
+	// Returns the URL of the stored artifact, the URL is signed and expires unless the storage is public
+	Url(key string) string
+
+	// Removes the artifact, missing artifact is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Local keeps the artifacts in the resources directory, the engine serves
+// them only on the signed URL
+type Local struct {
+	Directory string
+	BaseUrl   string
+	Secret    []byte
+	Ttl       time.Duration
+}
+
+func NewLocal(directory string, baseUrl string, secret []byte, ttl time.Duration) *Local {
+	return &Local{
+		Directory: directory,
+		BaseUrl:   strings.TrimSuffix(baseUrl, "/") + "/",
+		Secret:    secret,
+		Ttl:       ttl,
+	}
+}
+
+func (local *Local) Name() string {
+	return "local"
+}
+
+func (local *Local) Put(ctx context.Context, key string, path string) (string, error) {
+	target := filepath.Join(local.Directory, key)
+
+	if filepath.Clean(path) != target {
+		if errorDirectory := os.MkdirAll(filepath.Dir(target), 0755); errorDirectory != nil {
+			return "", errorDirectory
+		}
+
+		if errorRename := os.Rename(path, target); errorRename != nil {
+			return "", errorRename
+		}
+	}
+
+	return local.Url(key), nil
+}
+
+func (local *Local) Url(key string) string {
+	escaped := (&url.URL{Path: key}).EscapedPath()
+
+	return local.BaseUrl + escaped + "?" + lib.SignArtifact(local.Secret, key, time.Now().Add(local.Ttl))
+}
+
+func (local *Local) Delete(ctx context.Context, key string) error {
+	errorRemove := os.Remove(filepath.Join(local.Directory, key))
+
+	if errors.Is(errorRemove, os.ErrNotExist) {
+		return nil
+	}
+
+	return errorRemove
+}
+
+// S3 uploads the artifacts into the bucket of S3 compatible storage, such as MinIO,
+// the URL is presigned unless the public URL of the bucket is given
+type S3 struct {
+	Client    *minio.Client
+	Bucket    string
+	PublicUrl string
+	Ttl       time.Duration
+}
+
+// Creates the S3 storage, the bucket is created when it does not exist yet
+func NewS3(endpoint string, accessKey string, secretKey string, bucket string, region string, secure bool, publicUrl string, ttl time.Duration) (*S3, error) {
+	client, errorClient := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: secure,
+		Region: region,
+	})
+
+	if errorClient != nil {
+		return nil, errorClient
+	}
+
+	ctx := context.Background()
+	exists, errorExists := client.BucketExists(ctx, bucket)
+
+	if errorExists != nil {
+		return nil, errorExists
+	}
+
+	if !exists {
+		errorBucket := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: region})
+
+		if errorBucket != nil {
+			return nil, errorBucket
+		}
+	}
+
+	if publicUrl != "" {
+		publicUrl = strings.TrimSuffix(publicUrl, "/") + "/"
+	}
+
+	return &S3{
+		Client:    client,
+		Bucket:    bucket,
+		PublicUrl: publicUrl,
+		Ttl:       ttl,
+	}, nil
+}
+
+func (s3 *S3) Name() string {
+	return "s3"
+}
+
+// Uploads the local file and removes it, so the engine keeps no copy of the artifact
+func (s3 *S3) Put(ctx context.Context, key string, path string) (string, error) {
+	_, errorUpload := s3.Client.FPutObject(ctx, s3.Bucket, key, path, minio.PutObjectOptions{
+		ContentType: mime.TypeByExtension(filepath.Ext(key)),
+	})
+
+	if errorUpload != nil {
+		return "", errorUpload
+	}
+
+	os.Remove(path)
+
+	return s3.Url(key), nil
+}
+
+func (s3 *S3) Url(key string) string {
+	if s3.PublicUrl != "" {
+		return s3.PublicUrl + key
+	}
+
+	// Presigned URL is valid for 7 days at most
+	ttl := s3.Ttl
+
+	if ttl <= 0 || ttl > 7*24*time.Hour {
+		ttl = 7 * 24 * time.Hour
+	}
+
+	presigned, errorPresign := s3.Client.PresignedGetObject(context.Background(), s3.Bucket, key, ttl, nil)
+
+	if errorPresign != nil {
+		return s3.Client.EndpointURL().String() + "/" + s3.Bucket + "/" + key
+	}
+
+	return presigned.String()
+}
+
+func (s3 *S3) Delete(ctx context.Context, key string) error {
+	return s3.Client.RemoveObject(ctx, s3.Bucket, key, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,236 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"engine/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketRuns = []byte("runs")
+
+// Index of the run ids ordered by the start time, the key is the start time
+// in nanoseconds followed by the run id so the cursor walks the runs by time
+var bucketStarted = []byte("started")
+
+// Store keeps the history of every flow run in an embedded bbolt database
+type Store struct {
+	db *bolt.DB
+}
+
+// Opens the database file and creates the buckets when they are missing
+func Open(path string) (*Store, error) {
+	errorDirectory := os.MkdirAll(filepath.Dir(path), 0755)
+
+	if errorDirectory != nil {
+		return nil, errorDirectory
+	}
+
+	db, errorOpen := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+
+	if errorOpen != nil {
+		return nil, errorOpen
+	}
+
+	errorBucket := db.Update(func(tx *bolt.Tx) error {
+		runs, errorCreate := tx.CreateBucketIfNotExists(bucketRuns)
+
+		if errorCreate != nil {
+			return errorCreate
+		}
+
+		if tx.Bucket(bucketStarted) != nil {
+			return nil
+		}
+
+		// Database of the older engine has no index yet, the index is built from the saved runs
+		index, errorIndex := tx.CreateBucket(bucketStarted)
+
+		if errorIndex != nil {
+			return errorIndex
+		}
+
+		return runs.ForEach(func(key []byte, data []byte) error {
+			var run types.Run
+
+			if errorUnmarshal := json.Unmarshal(data, &run); errorUnmarshal != nil {
+				return errorUnmarshal
+			}
+
+			return index.Put(startedKey(run), key)
+		})
+	})
+
+	if errorBucket != nil {
+		db.Close()
+		return nil, errorBucket
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+// Saves the run, an existing run with the same id is replaced
+func (store *Store) Save(run types.Run) error {
+	data, errorMarshal := json.Marshal(run)
+
+	if errorMarshal != nil {
+		return errorMarshal
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, run, data)
+	})
+}
+
+// Puts the run and moves its index entry when the start time is changed
+func put(tx *bolt.Tx, run types.Run, data []byte) error {
+	bucket := tx.Bucket(bucketRuns)
+	index := tx.Bucket(bucketStarted)
+
+	if previous := bucket.Get([]byte(run.Id)); previous != nil {
+		var saved types.Run
+
+		if json.Unmarshal(previous, &saved) == nil {
+			if errorDelete := index.Delete(startedKey(saved)); errorDelete != nil {
+				return errorDelete
+			}
+		}
+	}
+
+	if errorIndex := index.Put(startedKey(run), []byte(run.Id)); errorIndex != nil {
+		return errorIndex
+	}
+
+	return bucket.Put([]byte(run.Id), data)
+}
+
+// Returns the index key of the run, the big endian time keeps the byte order of the keys
+func startedKey(run types.Run) []byte {
+	key := make([]byte, 8, 8+len(run.Id))
+	binary.BigEndian.PutUint64(key, nanoseconds(run.Started))
+
+	return append(key, run.Id...)
+}
+
+// Returns the time in nanoseconds, the run which never started is the oldest one
+func nanoseconds(started time.Time) uint64 {
+	if started.IsZero() || started.UnixNano() < 0 {
+		return 0
+	}
+
+	return uint64(started.UnixNano())
+}
+
+// Changes the saved run in a single transaction, the missing run is not changed
+func (store *Store) Update(id string, change func(run *types.Run)) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketRuns)
+		data := bucket.Get([]byte(id))
+
+		if data == nil {
+			return nil
+		}
+
+		run := types.Run{}
+
+		if errorUnmarshal := json.Unmarshal(data, &run); errorUnmarshal != nil {
+			return errorUnmarshal
+		}
+
+		change(&run)
+
+		data, errorMarshal := json.Marshal(run)
+
+		if errorMarshal != nil {
+			return errorMarshal
+		}
+
+		return put(tx, run, data)
+	})
+}
+
+// Returns the run by id, or nil when the run is not found
+func (store *Store) Get(id string) (*types.Run, error) {
+	var run *types.Run
+
+	errorView := store.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketRuns).Get([]byte(id))
+
+		if data == nil {
+			return nil
+		}
+
+		run = &types.Run{}
+
+		return json.Unmarshal(data, run)
+	})
+
+	return run, errorView
+}
+
+// Returns the runs matching the filter, newest run first, the runs are read from
+// the newest one and the scan stops at the limit or at the runs older than since
+func (store *Store) Find(filter types.RunFilter) ([]types.Run, error) {
+	runs := make([]types.Run, 0)
+	name := strings.ToLower(filter.Name)
+
+	errorView := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketRuns)
+		cursor := tx.Bucket(bucketStarted).Cursor()
+
+		for key, id := cursor.Last(); key != nil; key, id = cursor.Prev() {
+			if filter.Limit > 0 && len(runs) >= filter.Limit {
+				return nil
+			}
+
+			if !filter.Since.IsZero() && binary.BigEndian.Uint64(key[:8]) < nanoseconds(filter.Since) {
+				return nil
+			}
+
+			data := bucket.Get(id)
+
+			if data == nil {
+				continue
+			}
+
+			var run types.Run
+
+			errorUnmarshal := json.Unmarshal(data, &run)
+
+			if errorUnmarshal != nil {
+				return errorUnmarshal
+			}
+
+			if filter.Owner != "" && run.Owner != filter.Owner {
+				continue
+			}
+
+			if name != "" && !strings.Contains(strings.ToLower(run.Name), name) {
+				continue
+			}
+
+			if filter.Status != "" && run.Status != filter.Status {
+				continue
+			}
+
+			runs = append(runs, run)
+		}
+
+		return nil
+	})
+
+	if errorView != nil {
+		return nil, errorView
+	}
+
+	return runs, nil
+}
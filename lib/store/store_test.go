@@ -0,0 +1,71 @@
+package store
+
+import (
+	"engine/types"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFind(t *testing.T) {
+	store, errorOpen := Open(filepath.Join(t.TempDir(), "runs.db"))
+
+	if errorOpen != nil {
+		t.Fatal(errorOpen)
+	}
+
+	defer store.Close()
+
+	now := time.Now()
+
+	for index, id := range []string{"c", "a", "d", "b"} {
+		run := types.Run{Id: id, Name: "flow", Status: "succeeded", Started: now.Add(time.Duration(index) * time.Minute)}
+
+		if errorSave := store.Save(run); errorSave != nil {
+			t.Fatal(errorSave)
+		}
+	}
+
+	// Moving the start time keeps a single index entry of the run
+	if errorUpdate := store.Update("c", func(run *types.Run) { run.Started = now.Add(time.Hour) }); errorUpdate != nil {
+		t.Fatal(errorUpdate)
+	}
+
+	tests := []struct {
+		name   string
+		filter types.RunFilter
+		want   []string
+	}{
+		{"newest first", types.RunFilter{}, []string{"c", "b", "d", "a"}},
+		{"limit", types.RunFilter{Limit: 2}, []string{"c", "b"}},
+		{"since", types.RunFilter{Since: now.Add(90 * time.Second)}, []string{"c", "b", "d"}},
+		{"since and limit", types.RunFilter{Since: now.Add(90 * time.Second), Limit: 1}, []string{"c"}},
+		{"status", types.RunFilter{Status: "failed"}, []string{}},
+	}
+
+	for _, test := range tests {
+		runs, errorFind := store.Find(test.filter)
+
+		if errorFind != nil {
+			t.Fatal(errorFind)
+		}
+
+		ids := make([]string, 0, len(runs))
+
+		for _, run := range runs {
+			ids = append(ids, run.Id)
+		}
+
+		if len(ids) != len(test.want) {
+			t.Errorf("%s: want %v have %v", test.name, test.want, ids)
+			continue
+		}
+
+		for index := range ids {
+			if ids[index] != test.want[index] {
+				t.Errorf("%s: want %v have %v", test.name, test.want, ids)
+				break
+			}
+		}
+	}
+}
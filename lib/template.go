@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var templatePattern = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*((?:\|\s*[A-Za-z_][A-Za-z0-9_]*\s*)*)}}`)
+
+var templateFilters = map[string]func(string) string{
+	"urlencode": url.QueryEscape,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.TrimSpace,
+}
+
+// Replaces every {{ name | filter }} of the text with the value of the variable,
+// the template of unknown variable is kept as it is written
+func Render(text string, lookup func(name string) (string, bool)) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+
+	return templatePattern.ReplaceAllStringFunc(text, func(template string) string {
+		parts := templatePattern.FindStringSubmatch(template)
+		value, exists := lookup(parts[1])
+
+		if !exists {
+			return template
+		}
+
+		for _, filter := range filters(parts[2]) {
+			if apply, known := templateFilters[filter]; known {
+				value = apply(value)
+			}
+		}
+
+		return value
+	})
+}
+
+// Checks whether every filter of the templates in the text is known
+func CheckTemplate(text string) error {
+	for _, parts := range templatePattern.FindAllStringSubmatch(text, -1) {
+		for _, filter := range filters(parts[2]) {
+			if _, known := templateFilters[filter]; !known {
+				return fmt.Errorf("unknown filter %s of variable %s", filter, parts[1])
+			}
+		}
+	}
+
+	return nil
+}
+
+func filters(chain string) []string {
+	var names []string
+
+	for _, name := range strings.Split(chain, "|") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
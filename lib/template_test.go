@@ -0,0 +1,59 @@
+package lib
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	variables := map[string]string{
+		"query": "  Red Shoes ",
+		"page":  "2",
+	}
+
+	lookup := func(name string) (string, bool) {
+		value, exists := variables[name]
+
+		return value, exists
+	}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"no template", "no template"},
+		{"{{ page }}", "2"},
+		{"{{page}}", "2"},
+		{"{{ query | trim }}", "Red Shoes"},
+		{"{{ query | trim | lower }}", "red shoes"},
+		{"{{ query | trim | upper }}", "RED SHOES"},
+		{"{{ query | trim | lower | urlencode }}", "red+shoes"},
+		{"{{ query | urlencode | trim }}", "++Red+Shoes+"},
+		{"/search?q={{ query | trim | urlencode }}&page={{ page }}", "/search?q=Red+Shoes&page=2"},
+		{"{{ missing | trim }}", "{{ missing | trim }}"},
+	}
+
+	for _, test := range tests {
+		if got := Render(test.text, lookup); got != test.want {
+			t.Errorf("%s: want %q have %q", test.text, test.want, got)
+		}
+	}
+}
+
+func TestCheckTemplate(t *testing.T) {
+	tests := []struct {
+		text  string
+		valid bool
+	}{
+		{"plain text", true},
+		{"{{ query }}", true},
+		{"{{ query | trim | lower | upper | urlencode }}", true},
+		{"{{ query | trim | reverse }}", false},
+		{"{{ a }} and {{ b | base64 }}", false},
+	}
+
+	for _, test := range tests {
+		errorCheck := CheckTemplate(test.text)
+
+		if (errorCheck == nil) != test.valid {
+			t.Errorf("%s: want valid %v have %v", test.text, test.valid, errorCheck)
+		}
+	}
+}
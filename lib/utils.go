@@ -1,13 +1,17 @@
 package lib
 
 import (
+	"context"
 	"encoding/json"
 	"engine/types"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 )
@@ -15,7 +19,9 @@ import (
 var replacerQuote = strings.NewReplacer(`"`, `$"`, "\n", "$\n")
 var replacerJson = strings.NewReplacer(`"{`, `{`, `}"`, `}`, `"[`, `[`, `]"`, `]`, `$\"`, `"`, `$\n`, "\n", `\!`, `!`, `\@`, `@`, `\#`, `#`, `\$`, `$`, `\%`, `%`, `\^`, `^`, `\&`, `&`, `\*`, `*`, `\(`, `(`, `\)`, `)`, `\-`, `-`, `\+`, `+`, `\_`, `_`)
 
-func Response(w http.ResponseWriter, data types.Result, pageId string) {
+// Writes the flow result, legacy result keeps the table as unquoted JSON string
+// inside the content for clients which are built on the old result shape
+func Response(w http.ResponseWriter, data types.Result, pageId string, legacy bool) {
 	yellow := color.New(color.FgYellow).SprintFunc()
 
 	if pageId != "" {
@@ -25,19 +31,56 @@ func Response(w http.ResponseWriter, data types.Result, pageId string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	for _, page := range data.Result {
-		for index := range page.Content {
-			if strings.Contains(page.Content[index].Content, `[`) {
-				page.Content[index].Content = replacerQuote.Replace(page.Content[index].Content)
+	if !legacy {
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(data)
+		return
+	}
+
+	w.Write([]byte(LegacyJson(data)))
+}
+
+// Returns the result in the old JSON shape, the result itself is not changed
+func LegacyJson(data types.Result) string {
+	pages := make([]types.ResultPage, len(data.Result))
+
+	for pageIndex, page := range data.Result {
+		contents := make([]types.ResultContent, len(page.Content))
+
+		for index, content := range page.Content {
+			if content.Table != nil {
+				jsonTable, _ := json.Marshal(content.Table)
+
+				content.Length = len(jsonTable)
+				content.Content = string(jsonTable)
+				content.Table = nil
+			}
+
+			if strings.Contains(content.Content, `[`) {
+				content.Content = replacerQuote.Replace(content.Content)
 			}
+
+			contents[index] = content
 		}
+
+		page.Content = contents
+		pages[pageIndex] = page
 	}
 
+	data.Result = pages
+
 	jsonTable, _ := json.Marshal(data)
 	jsonEncoded := Unescape(jsonTable)
-	jsonResult := replacerJson.Replace(jsonEncoded)
 
-	w.Write([]byte(jsonResult))
+	return replacerJson.Replace(jsonEncoded)
+}
+
+func JSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(data)
 }
 
 func Noop(w http.ResponseWriter, r *http.Request) {}
@@ -56,6 +99,16 @@ func Unescape(json json.RawMessage) string {
 	return result
 }
 
+func EnvInt(name string, fallback int) int {
+	value, errorConvert := strconv.Atoi(os.Getenv(name))
+
+	if errorConvert != nil {
+		return fallback
+	}
+
+	return value
+}
+
 func Contains(sl []string, name string) bool {
 	for _, value := range sl {
 		if value == name {
@@ -66,28 +119,43 @@ func Contains(sl []string, name string) bool {
 	return false
 }
 
-func Cors(w *http.ResponseWriter, req *http.Request) {
-	(*w).Header().Set("Access-Control-Allow-Origin", "*")
+func Cors(w *http.ResponseWriter, req *http.Request, origins []string) {
+	origin := req.Header.Get("Origin")
+
+	if len(origins) == 0 || Contains(origins, "*") {
+		(*w).Header().Set("Access-Control-Allow-Origin", "*")
+	} else if origin != "" && Contains(origins, origin) {
+		(*w).Header().Set("Access-Control-Allow-Origin", origin)
+		(*w).Header().Add("Vary", "Origin")
+	}
+
 	(*w).Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+	(*w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Api-Key")
 }
 
 func Tesseract() (string, error) {
-	output, err := exec.Command("tesseract", "--version").Output()
-
-	if err != nil {
-		return "", err
-	}
-
-	return string(output[10:15]), nil
+	return version(10, "tesseract", "--version")
 }
 
 func Ffmpeg() (string, error) {
-	output, err := exec.Command("ffmpeg", "-version").Output()
+	return version(15, "ffmpeg", "-version")
+}
+
+// Returns the version printed by the command at the offset, the command which
+// hangs is stopped after a few seconds
+func version(offset int, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, name, args...).Output()
 
 	if err != nil {
 		return "", err
 	}
 
-	return string(output[15:20]), nil
+	if len(output) < offset+5 {
+		return "", fmt.Errorf("unknown version output of %s", name)
+	}
+
+	return string(output[offset : offset+5]), nil
 }
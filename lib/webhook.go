@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"engine/types"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Webhook sends the signed payload into the callback URL of the flow
+type Webhook struct {
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+// Creates the webhook sender with the default client, retries and backoff, the client
+// refuses loopback, private and link-local addresses unless WEBHOOK_ALLOW_PRIVATE is true
+func NewWebhook() Webhook {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	if os.Getenv(`WEBHOOK_ALLOW_PRIVATE`) != "true" {
+		dialer.Control = publicAddress
+	}
+
+	return Webhook{
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+		Retries: EnvInt(`WEBHOOK_RETRIES`, 3),
+		Backoff: time.Duration(EnvInt(`WEBHOOK_BACKOFF`, 1)) * time.Second,
+	}
+}
+
+// Events which are sent to the webhook, the final status of the run
+var webhookEvents = []string{types.JobSucceeded, types.JobFailed, types.JobCancelled}
+
+// Checks whether the webhook URL is an absolute http or https URL and every
+// subscribed event is sent by the engine
+func CheckWebhook(hook types.Webhook) error {
+	for _, event := range hook.Events {
+		if !Contains(webhookEvents, event) {
+			return fmt.Errorf("event %s is unknown, use %s", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+
+	if hook.Url == "" {
+		return nil
+	}
+
+	parsed, errorParse := url.Parse(hook.Url)
+
+	if errorParse != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return fmt.Errorf("url %s must be an absolute http or https url", hook.Url)
+	}
+
+	return nil
+}
+
+// Refuses the connection after the host is resolved, so the name which resolves into
+// the internal network is refused the same way as the internal address itself
+func publicAddress(network string, address string, conn syscall.RawConn) error {
+	host, _, errorSplit := net.SplitHostPort(address)
+
+	if errorSplit != nil {
+		return errorSplit
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+
+	return nil
+}
+
+// Returns the HMAC-SHA256 signature of the body using the webhook secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks whether the event is subscribed, empty events means every event
+func Subscribed(hook types.Webhook, event string) bool {
+	if hook.Url == "" {
+		return false
+	}
+
+	return len(hook.Events) == 0 || Contains(hook.Events, event)
+}
+
+// Posts the payload and retries with exponential backoff until it is delivered,
+// every attempt is returned so the caller can keep the delivery log
+func (webhook Webhook) Deliver(ctx context.Context, hook types.Webhook, payload types.WebhookPayload) []types.WebhookDelivery {
+	var deliveries []types.WebhookDelivery
+
+	body, errorMarshal := json.Marshal(payload)
+
+	if errorMarshal != nil {
+		return append(deliveries, types.WebhookDelivery{
+			Event: payload.Event,
+			Error: errorMarshal.Error(),
+			Sent:  time.Now(),
+		})
+	}
+
+	backoff := webhook.Backoff
+
+	for attempt := 1; attempt <= webhook.Retries+1; attempt++ {
+		delivery := webhook.send(ctx, hook, payload, body)
+		delivery.Attempt = attempt
+
+		deliveries = append(deliveries, delivery)
+
+		if delivery.Error == "" {
+			break
+		}
+
+		if attempt > webhook.Retries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return deliveries
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+
+	return deliveries
+}
+
+func (webhook Webhook) send(ctx context.Context, hook types.Webhook, payload types.WebhookPayload, body []byte) types.WebhookDelivery {
+	start := time.Now()
+
+	delivery := types.WebhookDelivery{
+		Event: payload.Event,
+		Sent:  start,
+	}
+
+	request, errorRequest := http.NewRequestWithContext(ctx, "POST", hook.Url, bytes.NewReader(body))
+
+	if errorRequest != nil {
+		delivery.Error = errorRequest.Error()
+		return delivery
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Owl-Engine-Webhook")
+	request.Header.Set("X-Owl-Event", payload.Event)
+	request.Header.Set("X-Owl-Delivery", payload.Id)
+
+	if hook.Secret != "" {
+		request.Header.Set("X-Owl-Signature", Sign(hook.Secret, body))
+	}
+
+	response, errorResponse := webhook.Client.Do(request)
+
+	delivery.Duration = time.Since(start) / 1000000
+
+	if errorResponse != nil {
+		delivery.Error = errorResponse.Error()
+		return delivery
+	}
+
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	delivery.Status = response.StatusCode
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		delivery.Error = fmt.Sprintf("Receiver responded with status %d", response.StatusCode)
+	}
+
+	return delivery
+}
@@ -0,0 +1,181 @@
+package lib
+
+import (
+	"context"
+	"engine/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	signature := Sign("secret", []byte(`{"event":"succeeded"}`))
+
+	if signature != "sha256=b8dc884e7259a488a1e732ef2d13c493e8725d49de03fe0d589e2547a55cb279" {
+		t.Fatalf("unexpected signature %s", signature)
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	tests := []struct {
+		name  string
+		hook  types.Webhook
+		event string
+		want  bool
+	}{
+		{"no url", types.Webhook{Events: []string{"succeeded"}}, "succeeded", false},
+		{"every event", types.Webhook{Url: "http://example.com"}, "failed", true},
+		{"subscribed event", types.Webhook{Url: "http://example.com", Events: []string{"succeeded"}}, "succeeded", true},
+		{"other event", types.Webhook{Url: "http://example.com", Events: []string{"succeeded"}}, "failed", false},
+	}
+
+	for _, test := range tests {
+		if got := Subscribed(test.hook, test.event); got != test.want {
+			t.Errorf("%s: want %v have %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestCheckWebhook(t *testing.T) {
+	tests := []struct {
+		url    string
+		events []string
+		valid  bool
+	}{
+		{"", nil, true},
+		{"https://example.com/hook", nil, true},
+		{"http://example.com:8080/hook", nil, true},
+		{"https://example.com/hook", []string{"succeeded", "failed", "cancelled"}, true},
+		{"https://example.com/hook", []string{"succeeded", "finished"}, false},
+		{"https://example.com/hook", []string{"success"}, false},
+		{"file:///etc/passwd", nil, false},
+		{"gopher://example.com", nil, false},
+		{"/hook", nil, false},
+	}
+
+	for _, test := range tests {
+		errorCheck := CheckWebhook(types.Webhook{Url: test.url, Events: test.events})
+
+		if (errorCheck == nil) != test.valid {
+			t.Errorf("%s %v: want valid %v have %v", test.url, test.events, test.valid, errorCheck)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var mutex sync.Mutex
+	var attempts []time.Time
+	var signatures []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		attempts = append(attempts, time.Now())
+
+		if r.Header.Get("X-Owl-Signature") == Sign("secret", body) {
+			signatures = append(signatures, r.Header.Get("X-Owl-Event"))
+		}
+
+		if len(attempts) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	defer server.Close()
+
+	webhook := Webhook{Client: server.Client(), Retries: 3, Backoff: 20 * time.Millisecond}
+	hook := types.Webhook{Url: server.URL, Secret: "secret"}
+
+	deliveries := webhook.Deliver(context.Background(), hook, types.WebhookPayload{Event: "succeeded", Id: "1"})
+
+	if len(deliveries) != 3 {
+		t.Fatalf("want 3 deliveries have %d", len(deliveries))
+	}
+
+	for index, delivery := range deliveries[:2] {
+		if delivery.Status != http.StatusInternalServerError || delivery.Error == "" || delivery.Attempt != index+1 {
+			t.Errorf("attempt %d should fail, have %+v", index+1, delivery)
+		}
+	}
+
+	if last := deliveries[2]; last.Status != http.StatusOK || last.Error != "" || last.Attempt != 3 {
+		t.Errorf("last attempt should be delivered, have %+v", last)
+	}
+
+	if len(signatures) != 3 {
+		t.Errorf("want every attempt signed, have %d of 3", len(signatures))
+	}
+
+	// Backoff is doubled after every failed attempt
+	if first, second := attempts[1].Sub(attempts[0]), attempts[2].Sub(attempts[1]); first < 20*time.Millisecond || second < 40*time.Millisecond {
+		t.Errorf("want backoff of 20ms and 40ms have %s and %s", first, second)
+	}
+}
+
+func TestDeliverStopsAfterRetries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	defer server.Close()
+
+	webhook := Webhook{Client: server.Client(), Retries: 2, Backoff: time.Millisecond}
+
+	deliveries := webhook.Deliver(context.Background(), types.Webhook{Url: server.URL}, types.WebhookPayload{Event: "failed"})
+
+	if len(deliveries) != 3 {
+		t.Fatalf("want 3 deliveries have %d", len(deliveries))
+	}
+}
+
+func TestDeliverStopsWhenCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	webhook := Webhook{Client: server.Client(), Retries: 5, Backoff: time.Hour}
+
+	deliveries := webhook.Deliver(ctx, types.Webhook{Url: server.URL}, types.WebhookPayload{Event: "failed"})
+
+	if len(deliveries) != 1 {
+		t.Fatalf("want 1 delivery have %d", len(deliveries))
+	}
+}
+
+func TestNewWebhookRefusesPrivateAddress(t *testing.T) {
+	var delivered int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&delivered, 1)
+	}))
+
+	defer server.Close()
+
+	t.Setenv("WEBHOOK_RETRIES", "0")
+
+	deliveries := NewWebhook().Deliver(context.Background(), types.Webhook{Url: server.URL}, types.WebhookPayload{Event: "succeeded"})
+
+	if atomic.LoadInt32(&delivered) == 1 || len(deliveries) != 1 || deliveries[0].Error == "" {
+		t.Fatalf("want the loopback address refused, have %+v", deliveries)
+	}
+
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+
+	deliveries = NewWebhook().Deliver(context.Background(), types.Webhook{Url: server.URL}, types.WebhookPayload{Event: "succeeded"})
+
+	if atomic.LoadInt32(&delivered) == 0 || deliveries[0].Error != "" {
+		t.Fatalf("want the loopback address allowed, have %+v", deliveries)
+	}
+}
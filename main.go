@@ -22,6 +22,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"encoding/json"
@@ -39,11 +40,12 @@ import (
 	"github.com/gosimple/slug"
 	"github.com/icza/mjpeg"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli"
 	"golang.org/x/net/html"
 )
 
-var engineProxyURL string
+var enginePublicURL string
 var engineBrowser rod.Browser
 
 var enginePort string
@@ -59,25 +61,11 @@ var resourcesDirectory string
 var imagesDirectory string
 var videoDirectory string
 var logsDirectory string
-
-var temporarySlugName string
-var temporaryDomainName string
-var temporaryNavigateUrl string
-var temporaryWrapperElement string
-var temporaryInfiniteScroll int
-
-var globalErrors []string
+var includesDirectory string
 
 var replacerPath *strings.Replacer
 var replacerSelector *strings.Replacer
 
-var headerDetection types.Proxy
-
-type VideoOutput struct {
-	out []byte
-	err error
-}
-
 /**
  * Engine v1.0.0
  */
@@ -86,22 +74,19 @@ func main() {
 
 	godotenv.Load(".env")
 
-	tesseractVersion, tesseractVersionError := lib.Tesseract()
-
-	if tesseractVersionError != nil {
-		panic("Tesseract is not installed")
-	}
+	red := color.New(color.FgRed).SprintFunc()
 
+	// Missing Tesseract or FFmpeg is reported by the readiness check instead of stopping the engine
+	tesseractVersion, tesseractVersionError := lib.Tesseract()
 	ffmpegVersion, ffmpegVersionError := lib.Ffmpeg()
 
-	if ffmpegVersionError != nil {
-		panic("FFmpeg is not installed")
-	}
+	enginePublicURL = os.Getenv("ENGINE_PUBLIC_URL")
 
-	engineProxyURL = os.Getenv("ENGINE_PROXY_URL")
+	// Deployments of the older release only set the proxy URL, the links keep working for them
+	if enginePublicURL == "" && os.Getenv("ENGINE_PROXY_URL") != "" {
+		enginePublicURL = os.Getenv("ENGINE_PROXY_URL")
 
-	if engineProxyURL == "" {
-		engineProxyURL = "https://owlengine.com/api/proxy?url="
+		log.Printf("%s ENGINE_PROXY_URL is deprecated, use ENGINE_PUBLIC_URL instead", yellow("[ Engine ]"))
 	}
 
 	defaultTimeout = 3 * time.Second
@@ -157,16 +142,51 @@ func main() {
 				Name:  "debug",
 				Usage: "Set debug mode on runtime",
 			},
+			&cli.StringFlag{
+				Name:  "keys",
+				Value: "",
+				Usage: "YAML file of API keys, authentication is disabled when empty",
+			},
+			&cli.StringFlag{
+				Name:  "schedules",
+				Value: "flows",
+				Usage: "Directory of flow files to run on their schedule, empty to disable",
+			},
+			&cli.StringFlag{
+				Name:  "includes",
+				Value: "flows",
+				Usage: "Directory of flow files which the include step of requests may read, empty to disable",
+			},
+			&cli.IntFlag{
+				Name:  "shutdown-timeout",
+				Value: 30,
+				Usage: "Seconds to wait for active flows on shutdown before stopping them",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			println("")
 			log.Printf("%s Starting engine\n", yellow("[ Engine ]"))
-			log.Printf("%s Using Tesseract version %s\n", yellow("[ Engine ]"), tesseractVersion)
-			log.Printf("%s Using FFmpeg version %s\n", yellow("[ Engine ]"), ffmpegVersion)
+			if tesseractVersionError != nil {
+				log.Printf(red("[ Engine ] Tesseract is not installed, OCR will not work\n"))
+			} else {
+				log.Printf("%s Using Tesseract version %s\n", yellow("[ Engine ]"), tesseractVersion)
+			}
+
+			if ffmpegVersionError != nil {
+				log.Printf(red("[ Engine ] FFmpeg is not installed, recording will not work\n"))
+			} else {
+				log.Printf("%s Using FFmpeg version %s\n", yellow("[ Engine ]"), ffmpegVersion)
+			}
 
 			enginePort = c.String("port")
+
+			if enginePublicURL == "" {
+				enginePublicURL = "http://127.0.0.1:" + enginePort
+			}
 			engineProxy = c.String("proxy")
 			engineDebug = c.Bool("debug")
+			shutdownTimeout = time.Duration(c.Int("shutdown-timeout")) * time.Second
+			includesDirectory = c.String("includes")
 
 			if engineProxy != "" {
 				useProxy = true
@@ -210,6 +230,13 @@ func main() {
 			log.Printf("%s Create a blank page", yellow("[ Engine ]"))
 			engineBrowser.MustPage("about:blank")
 
+			LoadKeys(c.String("keys"))
+			OpenStore()
+			OpenStorage()
+			Retention()
+			Queue()
+			Scheduler(c.String("schedules"))
+
 			log.Printf("%s Ready to handle scraper\n\n", yellow("[ Engine ]"))
 			Server()
 
@@ -228,48 +255,68 @@ func Server() {
 	red := color.New(color.FgRed).SprintFunc()
 	green := color.New(color.FgGreen).SprintFunc()
 
-	http.Handle("/resources/", http.StripPrefix("/resources/", http.FileServer(http.Dir(resourcesDirectory))))
-
-	http.HandleFunc("/", Pages)
+	http.HandleFunc("/artifacts/", Artifacts)
+
+	http.HandleFunc("/", Protect(Pages))
+	http.HandleFunc("/jobs", Protect(Jobs))
+	http.HandleFunc("/jobs/", Protect(Jobs))
+	http.HandleFunc("/runs", Protect(Runs))
+	http.HandleFunc("/runs/", Protect(Runs))
+	http.HandleFunc("/schedules", Protect(Schedules))
+	http.HandleFunc("/schedules/", Protect(Schedules))
+	http.HandleFunc("/usage", Protect(Usage))
+	http.HandleFunc("/retention", Protect(Retentions))
+	http.HandleFunc("/metrics", Protect(promhttp.Handler().ServeHTTP))
+	http.HandleFunc("/healthz", Health)
+	http.HandleFunc("/readyz", Ready)
 	http.HandleFunc("/favicon.ico", lib.Noop)
 
 	listener, errorListener := net.Listen("tcp4", ":"+enginePort)
 
-	strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
-
 	if errorListener != nil {
 		log.Printf(red("[ Engine ] %v"), errorListener)
-		globalErrors = append(globalErrors, `Something went wrong on our server`)
+		panic(fmt.Sprintf("Failed to listen on port %s, %v", enginePort, errorListener))
 	}
 
 	log.Printf("%s Server running on http://127.0.0.1:%s\n", green("[ Engine ]"), enginePort)
 	log.Printf("%s Waiting for connection\n\n", green("[ Engine ]"))
 
-	sign := make(chan os.Signal)
+	sign := make(chan os.Signal, 1)
 
 	signal.Notify(sign, os.Interrupt, syscall.SIGTERM, syscall.SIGABRT)
 
 	go func() {
 		<-sign
-		defer engineBrowser.MustClose()
-		os.Exit(1)
+
+		// Second signal stops the engine without waiting for the active flows
+		go func() {
+			<-sign
+			os.Exit(1)
+		}()
+
+		Shutdown(shutdownTimeout)
+		close(engineStopped)
 	}()
 
-	panic(http.Serve(listener, nil))
+	errorServe := engineServer.Serve(listener)
+
+	if errorServe != http.ErrServerClosed {
+		panic(errorServe)
+	}
+
+	<-engineStopped
 }
 
 func Pages(w http.ResponseWriter, r *http.Request) {
-	lib.Cors(&w, r)
-
 	if (*r).Method == "OPTIONS" {
 		return
 	}
 
 	unique := uuid.New().String()
 	pageId := unique[len(unique)-12:]
+	red := color.New(color.FgRed).SprintFunc()
 	yellow := color.New(color.FgYellow).SprintFunc()
 	green := color.New(color.FgGreen).SprintFunc()
-	red := color.New(color.FgRed).SprintFunc()
 
 	switch r.Method {
 	case "POST":
@@ -285,301 +332,670 @@ func Pages(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		errorInclude := Include(&request)
+
+		if errorInclude != nil {
+			lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: errorInclude.Error()})
+			return
+		}
+
+		errorAuthorize := Authorize(RequestKey(r), request)
+
+		if errorAuthorize != nil {
+			lib.JSON(w, http.StatusForbidden, types.Result{Code: 403, Message: errorAuthorize.Error()})
+			return
+		}
+
+		errorValidate := Validate(request)
+
+		if errorValidate == nil && !lib.ValidOutput(Output(r, request)) {
+			errorValidate = errors.New("Output must be json, csv, ndjson or xlsx")
+		}
+
+		if errorValidate != nil {
+			lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: errorValidate.Error()})
+			return
+		}
+
 		fmt.Printf("--- Process flow for #%s - %s\n\n", green(pageId), green(request.Name))
 
-		rootChannel := make(chan types.Result)
+		allowance, quotaCode, quotaMessage := Acquire(RequestKey(r))
 
-		go func(rootChannel chan types.Result) {
+		if quotaCode != "" {
+			lib.JSON(w, http.StatusTooManyRequests, types.Result{Code: 429, ErrorCode: quotaCode, Message: quotaMessage})
+			return
+		}
 
-			temporaryDomainName = ""     // Clean up temporary domain name
-			temporaryNavigateUrl = ""    // Clean up temporary navigate url
-			temporaryWrapperElement = "" // Clean up temporary wrapper element
-			temporaryInfiniteScroll = 0  // Clean up temporary infinite scroll
-			globalErrors = nil           // Clean up global errors
+		started := time.Now()
+		session := NewSession(r.Context(), request, pageId)
+		session.Owner = KeyName(RequestKey(r))
 
-			log.Printf("%s Flow ID : %s", yellow("[ Engine ]"), pageId)
-			log.Printf("%s Flow name : %s", yellow("[ Engine ]"), request.Name)
-			log.Printf("%s Flow target : %s\n\n", yellow("[ Engine ]"), request.FirstPage)
-			log.Printf("%s Starting flow", yellow("[ Engine ]"))
+		if !Track(session) {
+			allowance.Release(types.ResultUsage{})
 
-			if len(request.Flow) > 0 {
-				start := time.Now()
-				page := engineBrowser.MustPage()
+			lib.JSON(w, http.StatusServiceUnavailable, types.Result{Code: 503, ErrorCode: types.EngineShuttingDown, Message: "The engine is shutting down, try again later"})
+			return
+		}
 
-				headerString := page.MustNavigate("https://echo.owlengine.com/ua/latest").MustWaitLoad().MustElement("body").MustText()
-				json.Unmarshal([]byte(headerString), &headerDetection)
+		defer Untrack(session)
 
-				page.MustEmulate(devices.Device{
-					Title:          "Laptop Desktop",
-					UserAgent:      headerDetection.UserAgent.String,
-					AcceptLanguage: "en",
-				})
+		var result types.Result
 
-				// Enable screencast frame when user use record parameter
-				temporarySlugName = slug.Make(request.Name) + "-" + pageId
-				diskUsage := make(map[string]float64)
-				bandwidthUsage := make(map[string]float64)
-				videoPath := videoDirectory + temporarySlugName + ".mp4"
+		allowance.Watch(session)
 
-				go page.EachEvent(func(e *proto.NetworkResponseReceived) {
-					bandwidthUsage[strings.ToLower(string(e.Type))] += e.Response.EncodedDataLength
-				})()
+		// Reserved run is released even when the response cannot be written
+		defer func() { allowance.Release(session.Usage()) }()
 
-				if request.Record {
-					renderer, errorMjpeg := mjpeg.New(videoPath, int32(1440), int32(900), 6)
+		errorRun := rod.Try(func() {
+			result = Execute(session)
+		})
 
-					if errorMjpeg != nil {
-						log.Printf(red("[ Engine ] %v\n"), errorMjpeg)
-						globalErrors = append(globalErrors, `Failed to create temporary motion image`)
-					}
+		abortCode, _ := session.Aborted()
+
+		if errorRun != nil && (session.Cancelled() || abortCode != "") {
+			result = Interrupted(session, Partial(session, types.Result{
+				Id:   pageId,
+				Name: request.Name,
+			}))
+		} else if errorRun != nil {
+			log.Printf(red("[ Engine ] Flow #%s crashed, due to %v"), pageId, errorRun)
+
+			result = Partial(session, types.Result{
+				Id:      pageId,
+				Code:    500,
+				Name:    request.Name,
+				Message: "Failed to run Flow due some error on our Engine",
+			})
+			result.Errors = append(result.Errors, errorRun.Error())
+		}
 
-					go page.EachEvent(func(e *proto.PageScreencastFrame) {
-						renderer.AddFrame(e.Data)
+		metricFlowsFinished.WithLabelValues(ResultStatus(result)).Inc()
 
-						proto.PageScreencastFrameAck{
-							SessionID: e.SessionID,
-						}.Call(page)
-					})()
+		Record(session, result, started, started)
 
-					quality := int(100)
-					everyNthFrame := int(1)
+		go func() {
+			RecordDeliveries(pageId, Callback(request, result))
+		}()
 
-					proto.PageStartScreencast{
-						Format:        "jpeg",
-						Quality:       &quality,
-						EveryNthFrame: &everyNthFrame,
-					}.Call(page)
+		lib.Export(w, result, pageId, Output(r, request), request.LegacyJson)
 
-					defer renderer.Close()
-				}
+		log.Printf("%s Flow closed\n\n", yellow("[ Engine ]"))
+	default:
+		resultJson := types.Result{
+			Code:    400,
+			Message: "Method not allowed for this request",
+		}
 
-				paginateLimit := 1
-				itemsOnPageLimit := 1
+		lib.Response(w, resultJson, "", false)
+	}
+}
 
-				if request.Paginate && request.PaginateLimit > 0 {
-					paginateLimit = request.PaginateLimit
-				}
+/**
+ * Function to resolve the include and use steps of the request, the included
+ * files are read only from the includes directory of the engine and every
+ * include is refused when the directory is not configured
+ */
+func Include(request *types.Config) error {
+	return lib.Resolve(request, "request", includesDirectory, includesDirectory)
+}
 
-				if request.Infinite && request.InfiniteScroll > 0 {
-					paginateLimit = request.InfiniteScroll
-				}
+/**
+ * Function to check the result options of the flow before it is started
+ */
+func Validate(request types.Config) error {
+	if !lib.ValidOutput(request.Output) {
+		return errors.New("Output must be json, csv, ndjson or xlsx")
+	}
 
-				if request.ItemsOnPage > 0 {
-					itemsOnPageLimit = request.ItemsOnPage
-				}
+	if _, errorAge := ParseAge(request.Retention.MaxAge); errorAge != nil {
+		return fmt.Errorf("Retention max age %s is invalid, %v", request.Retention.MaxAge, errorAge)
+	}
 
-				temporaryScraperResult := make([]types.ResultPage, 0, paginateLimit)
+	if request.ResultVersion != 0 && request.ResultVersion != types.ResultVersionContent && request.ResultVersion != types.ResultVersionItems {
+		return fmt.Errorf("Result version must be %d or %d", types.ResultVersionContent, types.ResultVersionItems)
+	}
 
-				repetitionEnv := os.Getenv(`MAX_PAGINATE_LIMIT`)
+	if errorTemplate := lib.CheckTemplate(request.FirstPage); errorTemplate != nil {
+		return fmt.Errorf("First page has %v", errorTemplate)
+	}
 
-				if repetitionEnv != "" {
-					maximumRepetition, _ := strconv.Atoi(repetitionEnv)
+	if errorWebhook := lib.CheckWebhook(request.Webhook); errorWebhook != nil {
+		return fmt.Errorf("Webhook %v", errorWebhook)
+	}
 
-					if paginateLimit > maximumRepetition {
-						log.Printf("%s Limit parameter more than ENV want %d have %d", yellow("[ Engine ]"), maximumRepetition, paginateLimit)
-						globalErrors = append(globalErrors, fmt.Sprintf(`Maximum pagination only %d times, but requested %d times`, maximumRepetition, paginateLimit))
+	switch request.OnError {
+	case "", types.OnErrorContinue, types.OnErrorFail, types.OnErrorSkipItem:
+	default:
+		return errors.New("On error must be continue, fail or skip_item")
+	}
 
-						paginateLimit = maximumRepetition
-					}
-				}
+	if request.Timeout < 0 || (request.Retries != nil && *request.Retries < 0) || request.RetryBackoff < 0 {
+		return errors.New("Timeout, retries and retry backoff must not be negative")
+	}
 
-				itemsOnPageEnv := os.Getenv(`MAX_ITEMS_ON_PAGE`)
+	return Walk(request.Flow, "", func(path string, flowData types.Flow) error {
+		if flowData.Timeout < 0 || (flowData.Retries != nil && *flowData.Retries < 0) || flowData.RetryBackoff < 0 {
+			return fmt.Errorf("Step %s has negative timeout, retries or retry backoff", path)
+		}
 
-				if itemsOnPageEnv != "" {
-					maximumRepetition, _ := strconv.Atoi(itemsOnPageEnv)
+		if errorCondition := CheckCondition(flowData.If); errorCondition != nil {
+			return fmt.Errorf("Step %s %v", path, errorCondition)
+		}
 
-					if itemsOnPageLimit > maximumRepetition {
-						log.Printf("%s Limit items on page parameter more than ENV want %d have %d", yellow("[ Engine ]"), maximumRepetition, itemsOnPageLimit)
-						globalErrors = append(globalErrors, fmt.Sprintf(`Maximum items on page only %d items, but requested %d items`, maximumRepetition, itemsOnPageLimit))
+		encoded, _ := json.Marshal(flowData)
 
-						itemsOnPageLimit = maximumRepetition
-					}
-				}
+		if errorTemplate := lib.CheckTemplate(string(encoded)); errorTemplate != nil {
+			return fmt.Errorf("Step %s has %v", path, errorTemplate)
+		}
 
-				if itemsOnPageLimit > 0 {
-					paginateLimit = itemsOnPageLimit * paginateLimit
-				}
+		return nil
+	})
+}
 
-				parsedUrl, errorParseUrl := url.Parse(request.FirstPage)
+/**
+ * Function to return the result version of the flow, legacy JSON keeps the old result shape
+ */
+func ResultVersion(request types.Config) int {
+	if request.ResultVersion != 0 {
+		return request.ResultVersion
+	}
 
-				if errorParseUrl != nil {
-					log.Printf(red("[ Engine ] %v"), errorParseUrl)
-					globalErrors = append(globalErrors, `Failed to decode your first page URL`)
-				}
+	if request.LegacyJson {
+		return types.ResultVersionContent
+	}
 
-				temporaryDomainName = parsedUrl.Scheme + "__SCHEME__" + parsedUrl.Hostname()
-
-				isFinish, scraperResult := Flow(request, request.Flow, page, pageId, 0, paginateLimit, itemsOnPageLimit, temporaryScraperResult, diskUsage)
-
-				var resultJson = types.Result{
-					Id:             pageId,
-					Proxy:          headerDetection.IP,
-					Code:           200,
-					Name:           request.Name,
-					Slug:           temporarySlugName,
-					Message:        "The flow is running successfully",
-					Duration:       time.Since(start) / 1000000, // milisecond,
-					Engine:         string(request.Engine),
-					FirstPage:      string(request.FirstPage),
-					ItemsOnPage:    itemsOnPageLimit,
-					Infinite:       request.Infinite,
-					InfiniteScroll: request.InfiniteScroll,
-					Paginate:       request.Paginate,
-					PaginateLimit:  request.PaginateLimit,
-					Record:         request.Record,
-				}
+	return types.ResultVersionItems
+}
 
-				if isFinish {
-					// Stop screencast frame
-					proto.PageStopScreencast{}.Call(page)
-
-					// Remove all session, cookie, and cache from closed tab
-					proto.NetworkClearBrowserCache{}.Call(page)
-					proto.NetworkClearBrowserCookies{}.Call(page)
-					proto.PageDeleteCookie{}.Call(page)
-					proto.StorageClearCookies{}.Call(page)
-					proto.StorageClearDataForOrigin{}.Call(page)
-					proto.StorageClearTrustTokens{}.Call(page)
-					proto.DOMStorageClear{}.Call(page)
-
-					defer page.MustClose()
-
-					if request.Record {
-						time.Sleep(1 * time.Second)
-
-						compressedPath := strings.ReplaceAll(videoPath, ".mp4", "-compressed.mp4")
-
-						ch := make(chan VideoOutput)
-
-						go func() {
-							cmd := exec.Command("ffmpeg", "-i", videoPath, "-vcodec", "h264", "-acodec", "aac", compressedPath)
-							out, err := cmd.CombinedOutput()
-							ch <- VideoOutput{out, err}
-						}()
-
-						select {
-						case <-time.After(10 * time.Second):
-							log.Println(red("[ Engine ] Getting time out when compressing recording."))
-							globalErrors = append(globalErrors, `Failed to compress recorded video`)
-						case buffer := <-ch:
-							if buffer.err != nil {
-								log.Printf(red("[ Engine ] Failed to compress recording, error %v"), buffer.err)
-								globalErrors = append(globalErrors, `Failed to compress recorded video`)
-							} else {
-								log.Printf("%s Recording for #%s already compressed", green("[ Engine ]"), pageId)
+/**
+ * Function to return the output format of the result, the output query overrides the flow
+ */
+func Output(r *http.Request, request types.Config) string {
+	if output := r.URL.Query().Get("output"); output != "" {
+		return output
+	}
 
-								fileSize, errorFileSize := os.Stat(compressedPath)
+	return request.Output
+}
 
-								if errorFileSize != nil {
-									log.Printf(red("[ Engine ] %v"), errorFileSize)
-									globalErrors = append(globalErrors, `Failed to read recorded video size`)
-								} else {
-									diskUsage["videos"] += float64(fileSize.Size())
-								}
+/**
+ * Function to run a single flow request on a new browser page and build the result
+ */
+func Execute(session *Session) types.Result {
+	yellow := color.New(color.FgYellow).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
 
-								errorRemoveTemporary := os.Remove(videoPath)
+	session.Request.FirstPage = session.Render(session.Request.FirstPage)
 
-								if errorRemoveTemporary != nil {
-									log.Printf(red("[ Engine ] %v\n"), errorRemoveTemporary)
-									globalErrors = append(globalErrors, `Failed to remove temporary motion image`)
-								}
+	ctx := session.Context
+	request := session.Request
+	pageId := session.Id
 
-								errorRemoveCompressed := os.Rename(compressedPath, videoPath)
+	defer session.Close()
 
-								if errorRemoveCompressed != nil {
-									log.Printf(red("[ Engine ] %v\n"), errorRemoveCompressed)
-									globalErrors = append(globalErrors, `Failed to remove compressed motion image`)
-								}
+	log.Printf("%s Flow ID : %s", yellow("[ Engine ]"), pageId)
+	log.Printf("%s Flow name : %s", yellow("[ Engine ]"), request.Name)
+	log.Printf("%s Flow target : %s\n\n", yellow("[ Engine ]"), request.FirstPage)
+	log.Printf("%s Starting flow", yellow("[ Engine ]"))
 
-								resultJson.Recording = replacerPath.Replace(engineProxyURL + videoPath)
-							}
-						}
+	if len(request.Flow) > 0 {
+		start := time.Now()
 
-					}
+		// Browser tab without the request context, used for clean up after cancellation
+		tab := engineBrowser.MustPage()
+		page := tab.Context(ctx)
+		session.Page = page
 
-					if len(scraperResult) > 0 {
-						resultJson.Result = scraperResult
-					}
-				} else {
-					resultJson.Code = 500
-					resultJson.Message = "Failed to run Flow due some error on our Engine"
-				}
+		metricFlowsStarted.Inc()
+		metricPagesOpen.Inc()
+		atomic.AddInt64(&engineInFlight, 1)
 
-				resultJson.Usage = types.ResultUsage{
-					Bandwidth: bandwidthUsage,
-					Disk:      diskUsage,
-				}
-				resultJson.Errors = globalErrors
+		defer atomic.AddInt64(&engineInFlight, -1)
+		defer metricPagesOpen.Dec()
+		defer tab.Close()
+
+		headerString := tab.MustNavigate("https://echo.owlengine.com/ua/latest").MustWaitLoad().MustElement("body").MustText()
+		json.Unmarshal([]byte(headerString), &session.Header)
+
+		tab.MustEmulate(devices.Device{
+			Title:          "Laptop Desktop",
+			UserAgent:      session.Header.UserAgent.String,
+			AcceptLanguage: "en",
+		})
+
+		// Enable screencast frame when user use record parameter
+		session.SlugName = slug.Make(request.Name) + "-" + pageId
+		videoPath := videoDirectory + session.SlugName + ".mp4"
+
+		var renderer mjpeg.AviWriter
+
+		go tab.EachEvent(func(e *proto.NetworkResponseReceived) {
+			session.AddBandwidth(strings.ToLower(string(e.Type)), e.Response.EncodedDataLength)
+		})()
 
-				rootChannel <- resultJson
+		if request.Record {
+			motionImage, errorMjpeg := mjpeg.New(videoPath, int32(1440), int32(900), 6)
+
+			if errorMjpeg != nil {
+				log.Printf(red("[ Engine ] %v\n"), errorMjpeg)
+				session.AddError(`Failed to create temporary motion image`)
 			} else {
-				resultJson := types.Result{
-					Code:    404,
-					Message: "Flow not found for " + pageId,
-				}
+				renderer = motionImage
 
-				rootChannel <- resultJson
+				go tab.EachEvent(func(e *proto.PageScreencastFrame) {
+					renderer.AddFrame(e.Data)
+
+					proto.PageScreencastFrameAck{
+						SessionID: e.SessionID,
+					}.Call(tab)
+				})()
+
+				quality := int(100)
+				everyNthFrame := int(1)
+
+				proto.PageStartScreencast{
+					Format:        "jpeg",
+					Quality:       &quality,
+					EveryNthFrame: &everyNthFrame,
+				}.Call(tab)
 			}
-		}(rootChannel)
+		}
 
-		result := <-rootChannel
+		paginateLimit := 1
+		itemsOnPageLimit := 1
 
-		lib.Response(w, result, pageId)
+		if request.Paginate && request.PaginateLimit > 0 {
+			paginateLimit = request.PaginateLimit
+		}
 
-		log.Printf("%s Flow closed\n\n", yellow("[ Engine ]"))
-	default:
+		if request.Infinite && request.InfiniteScroll > 0 {
+			paginateLimit = request.InfiniteScroll
+		}
+
+		if request.ItemsOnPage > 0 {
+			itemsOnPageLimit = request.ItemsOnPage
+		}
+
+		temporaryScraperResult := make([]types.ResultPage, 0, paginateLimit)
+
+		repetitionEnv := os.Getenv(`MAX_PAGINATE_LIMIT`)
+
+		if repetitionEnv != "" {
+			maximumRepetition, _ := strconv.Atoi(repetitionEnv)
+
+			if paginateLimit > maximumRepetition {
+				log.Printf("%s Limit parameter more than ENV want %d have %d", yellow("[ Engine ]"), maximumRepetition, paginateLimit)
+				session.AddError(fmt.Sprintf(`Maximum pagination only %d times, but requested %d times`, maximumRepetition, paginateLimit))
+
+				paginateLimit = maximumRepetition
+			}
+		}
+
+		itemsOnPageEnv := os.Getenv(`MAX_ITEMS_ON_PAGE`)
+
+		if itemsOnPageEnv != "" {
+			maximumRepetition, _ := strconv.Atoi(itemsOnPageEnv)
+
+			if itemsOnPageLimit > maximumRepetition {
+				log.Printf("%s Limit items on page parameter more than ENV want %d have %d", yellow("[ Engine ]"), maximumRepetition, itemsOnPageLimit)
+				session.AddError(fmt.Sprintf(`Maximum items on page only %d items, but requested %d items`, maximumRepetition, itemsOnPageLimit))
+
+				itemsOnPageLimit = maximumRepetition
+			}
+		}
+
+		if itemsOnPageLimit > 0 {
+			paginateLimit = itemsOnPageLimit * paginateLimit
+		}
+
+		parsedUrl, errorParseUrl := url.Parse(request.FirstPage)
+
+		if errorParseUrl != nil {
+			log.Printf(red("[ Engine ] %v"), errorParseUrl)
+			session.AddError(`Failed to decode your first page URL`)
+		}
+
+		session.DomainName = parsedUrl.Scheme + "__SCHEME__" + parsedUrl.Hostname()
+
+		var isFinish bool
+		var scraperResult []types.ResultPage
+
+		errorFlow := rod.Try(func() {
+			isFinish, scraperResult = Flow(session, 0, paginateLimit, itemsOnPageLimit, temporaryScraperResult)
+		})
+
+		// Pages scraped before the flow stopped are kept by the session
+		if errorFlow != nil {
+			scraperResult, _ = session.Pages()
+		}
+
+		if errorFlow != nil && !session.Cancelled() {
+			log.Printf(red("[ Engine ] Flow stopped, due to %v"), errorFlow)
+			session.AddError(`Flow stopped due to error on requested page`)
+		}
+
+		var resultJson = types.Result{
+			Id:             pageId,
+			Proxy:          session.Header.IP,
+			Code:           200,
+			Name:           request.Name,
+			Slug:           session.SlugName,
+			Message:        "The flow is running successfully",
+			Duration:       time.Since(start) / 1000000, // milisecond,
+			Engine:         string(request.Engine),
+			FirstPage:      string(request.FirstPage),
+			ItemsOnPage:    itemsOnPageLimit,
+			Infinite:       request.Infinite,
+			InfiniteScroll: request.InfiniteScroll,
+			Paginate:       request.Paginate,
+			PaginateLimit:  request.PaginateLimit,
+			Record:         request.Record,
+		}
+
+		// Stop screencast frame
+		proto.PageStopScreencast{}.Call(tab)
+
+		// Remove all session, cookie, and cache from closed tab
+		proto.NetworkClearBrowserCache{}.Call(tab)
+		proto.NetworkClearBrowserCookies{}.Call(tab)
+		proto.PageDeleteCookie{}.Call(tab)
+		proto.StorageClearCookies{}.Call(tab)
+		proto.StorageClearDataForOrigin{}.Call(tab)
+		proto.StorageClearTrustTokens{}.Call(tab)
+		proto.DOMStorageClear{}.Call(tab)
+
+		if renderer != nil {
+			session.Sleep(1 * time.Second)
+
+			renderer.Close()
+
+			abortCode, _ := session.Aborted()
+
+			// Recording of the flow stopped by the shutdown or the failed step is kept with the partial result
+			if session.Cancelled() && abortCode != types.EngineShuttingDown && abortCode != types.StepFailed {
+				Discard(videoPath)
+			} else {
+				resultJson.Recording = Compress(session, videoPath)
+			}
+		}
+
+		if session.Cancelled() {
+			log.Printf(red("[ Engine ] Flow #%s stopped before it finished"), pageId)
+
+			resultJson = Interrupted(session, resultJson)
+
+			if len(scraperResult) > 0 {
+				resultJson.Result = scraperResult
+			}
+		} else if isFinish {
+			if len(scraperResult) > 0 {
+				resultJson.Result = scraperResult
+			}
+
+			log.Printf("%s Flow #%s finished", green("[ Engine ]"), pageId)
+		} else {
+			resultJson.Code = 500
+			resultJson.Message = "Failed to run Flow due some error on our Engine"
+			resultJson.Result = scraperResult
+		}
+
+		resultJson.Version = ResultVersion(request)
+
+		if resultJson.Version == types.ResultVersionItems {
+			resultJson.Pages, resultJson.Records = lib.Items(resultJson.Result, itemsOnPageLimit)
+			resultJson.Result = nil
+		}
+
+		resultJson.Usage = session.Usage()
+		resultJson.Errors = session.Errors()
+
+		for resourceType, size := range resultJson.Usage.Bandwidth {
+			metricBandwidth.WithLabelValues(resourceType).Add(size)
+		}
+
+		metricFlowDuration.Observe(time.Since(start).Seconds())
+
+		return resultJson
+	} else {
 		resultJson := types.Result{
-			Code:    400,
-			Message: "Method not allowed for this request",
+			Code:    404,
+			Message: "Flow not found for " + pageId,
 		}
 
-		lib.Response(w, resultJson, "")
+		return resultJson
 	}
 }
 
-func Flow(request types.Config, flow []types.Flow, page *rod.Page, pageId string, paginateIndex int, paginateLimit int, itemsOnPageLimit int, scraperResult []types.ResultPage, diskUsage map[string]float64) (bool, []types.ResultPage) {
+/**
+ * Function to mark the result of the session which is stopped before it finished,
+ * either cancelled by the client or aborted by the engine
+ */
+func Interrupted(session *Session, result types.Result) types.Result {
+	abortCode, abortMessage := session.Aborted()
+
+	if abortCode == types.EngineShuttingDown {
+		result.Code = 503
+		result.ErrorCode = abortCode
+		result.Message = abortMessage
+
+		return result
+	}
+
+	if abortCode == types.StepFailed {
+		result.Code = 422
+		result.ErrorCode = abortCode
+		result.Message = abortMessage
+		result.Failure = session.Failure()
+
+		return result
+	}
+
+	if abortCode != "" {
+		result.Code = 429
+		result.ErrorCode = abortCode
+		result.Message = abortMessage
+
+		return result
+	}
+
+	result.Code = 499
+	result.Cancelled = true
+	result.Message = "The flow was cancelled before it finished"
+
+	return result
+}
+
+/**
+ * Function to fill the result with the pages, usage and errors the session collected
+ * so far, used when the flow crashed before it returned the result
+ */
+func Partial(session *Session, result types.Result) types.Result {
+	pages, itemsOnPage := session.Pages()
+
+	result.Result = pages
+	result.ItemsOnPage = itemsOnPage
+	result.Version = ResultVersion(session.Request)
+
+	if result.Version == types.ResultVersionItems {
+		result.Pages, result.Records = lib.Items(result.Result, itemsOnPage)
+		result.Result = nil
+	}
+
+	result.Usage = session.Usage()
+	result.Errors = session.Errors()
+
+	return result
+}
+
+/**
+ * Function to compress the recorded motion image using FFmpeg and return the recording URL
+ */
+func Compress(session *Session, videoPath string) string {
+	red := color.New(color.FgRed).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+
+	compressedPath := strings.ReplaceAll(videoPath, ".mp4", "-compressed.mp4")
+
+	parentContext := session.Context
+
+	if abortCode, _ := session.Aborted(); abortCode == types.EngineShuttingDown || abortCode == types.StepFailed {
+		parentContext = context.Background()
+	}
+
+	compressContext, cancelCompress := context.WithTimeout(parentContext, 10*time.Second)
+	defer cancelCompress()
+
+	compressStart := time.Now()
+
+	_, errorCompress := exec.CommandContext(compressContext, "ffmpeg", "-i", videoPath, "-vcodec", "h264", "-acodec", "aac", compressedPath).CombinedOutput()
+
+	metricCompressionDuration.Observe(time.Since(compressStart).Seconds())
+
+	if errors.Is(compressContext.Err(), context.Canceled) {
+		Discard(videoPath, compressedPath)
+		return ""
+	}
+
+	if errors.Is(compressContext.Err(), context.DeadlineExceeded) {
+		log.Println(red("[ Engine ] Getting time out when compressing recording."))
+		metricCompressionFailures.Inc()
+		session.AddError(`Failed to compress recorded video`)
+		Discard(compressedPath)
+		return ""
+	}
+
+	if errorCompress != nil {
+		log.Printf(red("[ Engine ] Failed to compress recording, error %v"), errorCompress)
+		metricCompressionFailures.Inc()
+		session.AddError(`Failed to compress recorded video`)
+		Discard(compressedPath)
+		return ""
+	}
+
+	log.Printf("%s Recording for #%s already compressed", green("[ Engine ]"), session.Id)
+
+	fileSize, errorFileSize := os.Stat(compressedPath)
+
+	if errorFileSize != nil {
+		log.Printf(red("[ Engine ] %v"), errorFileSize)
+		session.AddError(`Failed to read recorded video size`)
+	} else {
+		session.AddDisk("videos", float64(fileSize.Size()))
+	}
+
+	errorRemoveTemporary := os.Remove(videoPath)
+
+	if errorRemoveTemporary != nil {
+		log.Printf(red("[ Engine ] %v\n"), errorRemoveTemporary)
+		session.AddError(`Failed to remove temporary motion image`)
+	}
+
+	errorRemoveCompressed := os.Rename(compressedPath, videoPath)
+
+	if errorRemoveCompressed != nil {
+		log.Printf(red("[ Engine ] %v\n"), errorRemoveCompressed)
+		session.AddError(`Failed to remove compressed motion image`)
+	}
+
+	return Upload(session, videoPath)
+}
+
+/**
+ * Function to remove temporary recording files, missing files are ignored
+ */
+func Discard(paths ...string) {
 	red := color.New(color.FgRed).SprintFunc()
 
+	for _, path := range paths {
+		errorRemove := os.Remove(path)
+
+		if errorRemove != nil && !os.IsNotExist(errorRemove) {
+			log.Printf(red("[ Engine ] %v\n"), errorRemove)
+		}
+	}
+}
+
+func Flow(session *Session, paginateIndex int, paginateLimit int, itemsOnPageLimit int, scraperResult []types.ResultPage) (bool, []types.ResultPage) {
+	red := color.New(color.FgRed).SprintFunc()
+
+	request := session.Request
+	page := session.Page
+
 	pageStart := time.Now()
 	temporaryContents := make([]types.ResultContent, 0, len(request.Flow))
 
 	if paginateIndex == 0 {
-		err := rod.Try(func() {
-			page.Timeout(10 * time.Second).MustNavigate(request.FirstPage)
-			page.WaitNavigation(proto.PageLifecycleEventNameNetworkIdle)
-			page.MustWaitLoad()
+		policy := Policy(request, types.Flow{}, 10*time.Second)
+
+		err := policy.Run(session, "navigation to "+request.FirstPage, func() error {
+			return rod.Try(func() {
+				page.Timeout(policy.Timeout).MustNavigate(request.FirstPage)
+				page.WaitNavigation(proto.PageLifecycleEventNameNetworkIdle)
+				page.MustWaitLoad()
+			})
 		})
 
 		if errors.Is(err, context.DeadlineExceeded) {
+			metricNavigationTimeouts.Inc()
 			log.Printf(red("[ Engine ] Failed to navigate to %s, due to context deadline exceeded"), request.FirstPage)
-			globalErrors = append(globalErrors, fmt.Sprintf(`Failed to navigate to %s, due to context deadline exceeded`, request.FirstPage))
+			session.AddError(fmt.Sprintf(`Failed to navigate to %s, due to context deadline exceeded`, request.FirstPage))
 		} else if err != nil {
 			log.Printf(red("[ Engine ] Failed to navigate to %s, due to %v"), request.FirstPage, err)
-			globalErrors = append(globalErrors, fmt.Sprintf(`Failed to navigate to %s, due to error on requested page`, request.FirstPage))
+			session.AddError(fmt.Sprintf(`Failed to navigate to %s, due to error on requested page`, request.FirstPage))
 		}
 	}
 
+	if session.Cancelled() {
+		return false, scraperResult
+	}
+
 	if itemsOnPageLimit > 0 && paginateLimit > 0 {
 		if paginateIndex >= itemsOnPageLimit && paginateIndex%itemsOnPageLimit == 0 && paginateIndex < paginateLimit {
-			if request.PaginateButton != "" {
-				page.MustElement(request.PaginateButton).MustClick()
+			policy := Policy(request, types.Flow{}, 10*time.Second)
+
+			errorPaginate := policy.Run(session, "paginate button "+request.PaginateButton, func() error {
+				return rod.Try(func() {
+					if request.PaginateButton != "" {
+						page.Timeout(policy.Timeout).MustElement(request.PaginateButton).MustClick()
+					}
+				})
+			})
+
+			if errorPaginate == nil {
+				errorPaginate = rod.Try(func() {
+					if request.Infinite && session.InfiniteScroll < request.InfiniteScroll {
+						page.Mouse.Scroll(0, float64(*page.MustGetWindow().Height)*4, 2)
+						session.InfiniteScroll++
+					}
+
+					page.MustWaitLoad()
+				})
 			}
 
-			if request.Infinite && temporaryInfiniteScroll < request.InfiniteScroll {
-				page.Mouse.Scroll(0, float64(*page.MustGetWindow().Height)*4, 2)
-				temporaryInfiniteScroll++
+			if session.Cancelled() || !session.Sleep(defaultTimeout) {
+				return false, scraperResult
 			}
 
-			page.MustWaitLoad()
-			time.Sleep(defaultTimeout)
+			if errorPaginate != nil {
+				log.Printf(red("[ Engine ] Failed to paginate page %d, due to %v"), paginateIndex/itemsOnPageLimit+1, errorPaginate)
+				session.AddError(fmt.Sprintf(`Failed to paginate into page %d`, paginateIndex/itemsOnPageLimit+1))
+			}
 		}
 	}
 
 	if paginateIndex < paginateLimit {
 
-		isFinish, pageContent := Parse(request, request.Flow, 0, len(request.Flow), page, pageId, paginateIndex, itemsOnPageLimit, temporaryContents, diskUsage)
+		isFinish, pageContent := Parse(session, request.Flow, 0, len(request.Flow), paginateIndex, itemsOnPageLimit, temporaryContents)
+
+		// Item with the failed step is dropped by on_error skip_item
+		if isFinish && !session.Cancelled() && session.skipItem {
+			session.skipItem = false
+
+			return Flow(session, paginateIndex+1, paginateLimit, itemsOnPageLimit, scraperResult)
+		}
 
-		if isFinish {
+		if isFinish && !session.Cancelled() {
 			scraperResult = append(scraperResult, types.ResultPage{
 				Title:    page.MustInfo().Title,
 				Url:      page.MustInfo().URL,
@@ -588,8 +1004,20 @@ func Flow(request types.Config, flow []types.Flow, page *rod.Page, pageId string
 				Content:  pageContent,
 			})
 
-			return Flow(request, request.Flow, page, pageId, paginateIndex+1, paginateLimit, itemsOnPageLimit, scraperResult, diskUsage)
+			session.KeepPages(scraperResult, itemsOnPageLimit)
+
+			return Flow(session, paginateIndex+1, paginateLimit, itemsOnPageLimit, scraperResult)
 		} else {
+			if len(pageContent) > 0 {
+				scraperResult = append(scraperResult, types.ResultPage{
+					Page:     paginateIndex + 1,
+					Duration: time.Since(pageStart) / 1000000,
+					Content:  pageContent,
+				})
+
+				session.KeepPages(scraperResult, itemsOnPageLimit)
+			}
+
 			return false, scraperResult
 		}
 	}
@@ -601,10 +1029,94 @@ func Flow(request types.Config, flow []types.Flow, page *rod.Page, pageId string
 	return false, scraperResult
 }
 
-func Parse(request types.Config, flow []types.Flow, current int, total int, page *rod.Page, pageId string, paginateIndex int, itemsOnPageLimit int, pageContent []types.ResultContent, diskUsage map[string]float64) (bool, []types.ResultContent) {
+/**
+ * Function to scope the selector into the wrapper and replace the loop placeholders
+ */
+func Selector(session *Session, selector string, paginateIndex int, itemIndex int) string {
+	selector = session.Render(selector)
+
+	if session.WrapperElement != "" {
+		selector = session.WrapperElement + " " + selector
+	}
+
+	if strings.Contains(selector, "$loop_index") {
+		selector = strings.ReplaceAll(selector, "$loop_index", strconv.Itoa(paginateIndex))
+	}
+
+	if strings.Contains(selector, "$loop_number") {
+		selector = strings.ReplaceAll(selector, "$loop_number", strconv.Itoa(paginateIndex+1))
+	}
+
+	if strings.Contains(selector, "$item_index") {
+		selector = strings.ReplaceAll(selector, "$item_index", strconv.Itoa(itemIndex))
+	}
+
+	if strings.Contains(selector, "$item_number") {
+		selector = strings.ReplaceAll(selector, "$item_number", strconv.Itoa(itemIndex+1))
+	}
+
+	return selector
+}
+
+/**
+ * Function to check whether the step failed, the nested steps of if, for_each and follow
+ * fail on their own while the required loop fails when it finds nothing
+ */
+func Failed(flowData types.Flow, resultContent types.ResultContent, stepEvent types.StepEvent) bool {
+	switch stepEvent.Kind {
+	case "if":
+		return false
+	case "for_each", "follow":
+		return flowData.Required && len(resultContent.Items) == 0
+	}
+
+	return stepEvent.Error != ""
+}
+
+/**
+ * Function to name the kind of step for the progress events
+ */
+func StepKind(flowData types.Flow) string {
+	switch {
+	case flowData.Delay != 0:
+		return "delay"
+	case flowData.Scroll > 0:
+		return "scroll"
+	case flowData.Navigate || flowData.BackToPrevious:
+		return "navigate"
+	case flowData.WaitFor.Selector != "":
+		return "wait_for"
+	case flowData.Capture.Name != "":
+		return "capture"
+	case flowData.Take.Selector != "" || flowData.Take.Contains.Selector != "":
+		return "take"
+	case flowData.Table.Selector != "":
+		return "table"
+	case flowData.Element.Selector != "" || flowData.Element.Contains.Selector != "":
+		return "element"
+	case flowData.Wrapper != "":
+		return "wrapper"
+	case IsCondition(flowData.If):
+		return "if"
+	case flowData.ForEach.Selector != "":
+		return "for_each"
+	case flowData.Follow.Selector != "":
+		return "follow"
+	}
+
+	return "unknown"
+}
+
+func Parse(session *Session, flow []types.Flow, current int, total int, paginateIndex int, itemsOnPageLimit int, pageContent []types.ResultContent) (bool, []types.ResultContent) {
 	red := color.New(color.FgRed).SprintFunc()
 	yellow := color.New(color.FgYellow).SprintFunc()
 
+	page := session.Page
+
+	if session.Cancelled() {
+		return false, pageContent
+	}
+
 	if current < total {
 		flowData := flow[current]
 
@@ -613,10 +1125,18 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 		var selectorText string
 		var resultContent types.ResultContent
 
+		stepStart := time.Now()
+		stepErrors := session.ErrorMark()
+		session.unset = nil
+
+		// Nested steps are addressed by the path of their parent step such as 2.then.0
+		stepPrefix := session.stepPath
+		stepPath := stepPrefix + strconv.Itoa(current)
+
 		currentItemIndex := paginateIndex - (itemsOnPageLimit * int(math.Floor(float64(paginateIndex)/float64(itemsOnPageLimit))))
 
 		if flowData.Wrapper != "" {
-			temporaryWrapperElement = flowData.Wrapper
+			session.WrapperElement = session.Render(flowData.Wrapper)
 		}
 
 		if flowData.Element.Selector != "" {
@@ -653,37 +1173,25 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 		}
 
 		if flowData.WaitFor.Selector != "" {
-			selectorText = flowData.Table.Selector
+			selectorText = flowData.WaitFor.Selector
 		}
 
-		if temporaryWrapperElement != "" {
-			selectorText = temporaryWrapperElement + " " + selectorText
+		if selectorText != "" {
+			selectorText = Selector(session, selectorText, paginateIndex, currentItemIndex)
 		}
 
-		if strings.Contains(selectorText, "$loop_index") {
-			selectorText = strings.ReplaceAll(selectorText, "$loop_index", strconv.Itoa(paginateIndex))
-		}
-
-		if strings.Contains(selectorText, "$loop_number") {
-			selectorText = strings.ReplaceAll(selectorText, "$loop_number", strconv.Itoa(paginateIndex+1))
-		}
-
-		if strings.Contains(selectorText, "$item_index") {
-			selectorText = strings.ReplaceAll(selectorText, "$item_index", strconv.Itoa(currentItemIndex))
-		}
-
-		if strings.Contains(selectorText, "$item_number") {
-			selectorText = strings.ReplaceAll(selectorText, "$item_number", strconv.Itoa(currentItemIndex+1))
-		}
+		policy := Policy(session.Request, flowData, defaultTimeout)
 
-		fieldError := rod.Try(func() {
-			if flowData.Element.Selector != "" || flowData.Table.Selector != "" || flowData.Take.Selector != "" || flowData.Capture.Name != "" {
-				detectedElement = page.Timeout(defaultTimeout).MustElement(selectorText)
-			} else if flowData.Element.Contains.Selector != "" {
-				detectedElement = page.Timeout(defaultTimeout).MustElementR(selectorText, flowData.Element.Contains.Identifier)
-			} else if flowData.Take.Contains.Selector != "" {
-				detectedElement = page.Timeout(defaultTimeout).MustElementR(selectorText, flowData.Take.Contains.Identifier)
-			}
+		fieldError := policy.Run(session, "selector "+selectorText, func() error {
+			return rod.Try(func() {
+				if flowData.Element.Selector != "" || flowData.Table.Selector != "" || flowData.Take.Selector != "" || flowData.Capture.Name != "" {
+					detectedElement = page.Timeout(policy.Timeout).MustElement(selectorText)
+				} else if flowData.Element.Contains.Selector != "" {
+					detectedElement = page.Timeout(policy.Timeout).MustElementR(selectorText, flowData.Element.Contains.Identifier)
+				} else if flowData.Take.Contains.Selector != "" {
+					detectedElement = page.Timeout(policy.Timeout).MustElementR(selectorText, flowData.Take.Contains.Identifier)
+				}
+			})
 		})
 
 		if flowData.Element.Contains.Identifier != "" {
@@ -694,12 +1202,17 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 			selectorText = selectorText + " `" + flowData.Take.Contains.Identifier + "`"
 		}
 
+		if session.Cancelled() {
+			return false, pageContent
+		}
+
 		if errors.Is(fieldError, context.DeadlineExceeded) {
+			metricSelectorNotFound.Inc()
 			log.Printf(red("[ Engine ] Selector %s not found"), selectorText)
-			globalErrors = append(globalErrors, fmt.Sprintf(`Failed to find selector %s for %s`, replacerSelector.Replace(selectorText), fieldName))
+			session.AddError(fmt.Sprintf(`Failed to find selector %s for %s`, replacerSelector.Replace(selectorText), fieldName))
 		} else if fieldError != nil {
 			log.Printf(red("[ Engine ] %v"), fieldError)
-			globalErrors = append(globalErrors, fmt.Sprintf(`Failed to find selector %s for %s`, replacerSelector.Replace(selectorText), fieldName))
+			session.AddError(fmt.Sprintf(`Failed to find selector %s for %s`, replacerSelector.Replace(selectorText), fieldName))
 		}
 
 		// Process without Element
@@ -707,7 +1220,10 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 		if flowData.Delay != 0 {
 
 			var sleepTime int = int(flowData.Delay)
-			time.Sleep(time.Second * time.Duration(sleepTime))
+
+			if !session.Sleep(time.Second * time.Duration(sleepTime)) {
+				return false, pageContent
+			}
 
 		} else if flowData.Scroll > 0 {
 
@@ -715,73 +1231,153 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 
 		} else if flowData.Navigate {
 
-			if temporaryNavigateUrl != "" {
-				temporaryWrapperElement = ""
+			navigateUrl := session.NavigateUrl
+
+			if flowData.Url != "" {
+				navigateUrl = session.Render(flowData.Url)
+			}
+
+			if navigateUrl != "" {
+				session.WrapperElement = ""
 
 				log.Printf(yellow("[ Engine ] Page Index %d"), paginateIndex)
-				log.Printf(yellow("[ Engine ] Navigate Url %s"), temporaryNavigateUrl)
+				log.Printf(yellow("[ Engine ] Navigate Url %s"), navigateUrl)
 
-				err := rod.Try(func() {
-					page.Timeout(10 * time.Second).MustNavigate(temporaryNavigateUrl)
-					page.WaitNavigation(proto.PageLifecycleEventNameNetworkIdle)
-					page.MustWaitLoad()
+				navigatePolicy := Policy(session.Request, flowData, 10*time.Second)
+
+				err := navigatePolicy.Run(session, "navigation to "+navigateUrl, func() error {
+					return rod.Try(func() {
+						page.Timeout(navigatePolicy.Timeout).MustNavigate(navigateUrl)
+						page.WaitNavigation(proto.PageLifecycleEventNameNetworkIdle)
+						page.MustWaitLoad()
+					})
 				})
 
 				if errors.Is(err, context.DeadlineExceeded) {
-					log.Printf(red("[ Engine ] Failed to navigate to %s, due to context deadline exceeded"), temporaryNavigateUrl)
-					globalErrors = append(globalErrors, fmt.Sprintf(`Failed to navigate to %s, due to context deadline exceeded`, temporaryNavigateUrl))
+					metricNavigationTimeouts.Inc()
+					log.Printf(red("[ Engine ] Failed to navigate to %s, due to context deadline exceeded"), navigateUrl)
+					session.AddError(fmt.Sprintf(`Failed to navigate to %s, due to context deadline exceeded`, navigateUrl))
 				} else if err != nil {
-					log.Printf(red("[ Engine ] Failed to navigate to %s, due to %v"), temporaryNavigateUrl, err)
-					globalErrors = append(globalErrors, fmt.Sprintf(`Failed to navigate to %s, due to error on requested page`, temporaryNavigateUrl))
+					log.Printf(red("[ Engine ] Failed to navigate to %s, due to %v"), navigateUrl, err)
+					session.AddError(fmt.Sprintf(`Failed to navigate to %s, due to error on requested page`, navigateUrl))
 				}
 			}
 
 		} else if flowData.BackToPrevious {
 
-			temporaryWrapperElement = ""
+			session.WrapperElement = ""
 
-			page.MustNavigateBack()
-			page.WaitNavigation(proto.PageLifecycleEventNameNetworkIdle)
-			page.MustWaitLoad()
+			backPolicy := Policy(session.Request, flowData, 10*time.Second)
 
-		}
+			// Only the load is retried, navigating back again would leave the previous page
+			err := rod.Try(func() { page.Timeout(backPolicy.Timeout).MustNavigateBack() })
 
-		// Process with Element
+			if err == nil {
+				err = backPolicy.Run(session, "navigation back", func() error {
+					return rod.Try(func() {
+						page.WaitNavigation(proto.PageLifecycleEventNameNetworkIdle)
+						page.Timeout(backPolicy.Timeout).MustWaitLoad()
+					})
+				})
+			}
 
-		if detectedElement != nil {
+			if err != nil {
+				log.Printf(red("[ Engine ] Failed to navigate back, due to %v"), err)
+				session.AddError(`Failed to navigate back to the previous page`)
+			}
 
-			if flowData.WaitFor.Selector != "" {
-				var waitTimeOut = 10 * time.Second
+		} else if flowData.WaitFor.Selector != "" {
 
-				if flowData.WaitFor.Delay > 0 {
-					var sleepTime int = int(flowData.WaitFor.Delay)
-					waitTimeOut = time.Second * time.Duration(sleepTime)
-				}
+			waitPolicy := Policy(session.Request, flowData, 10*time.Second)
+
+			if flowData.WaitFor.Delay > 0 {
+				var sleepTime int = int(flowData.WaitFor.Delay)
+				waitPolicy.Timeout = time.Second * time.Duration(sleepTime)
+			}
 
-				err := rod.Try(func() {
-					page.Timeout(waitTimeOut).MustElement(selectorText)
+			err := waitPolicy.Run(session, "selector "+selectorText, func() error {
+				return rod.Try(func() {
+					page.Timeout(waitPolicy.Timeout).MustElement(selectorText)
 					page.MustWaitLoad()
 				})
+			})
 
-				if errors.Is(err, context.DeadlineExceeded) {
-					log.Printf(red("[ Engine ] Failed to wait for selector %s, due to context deadline exceeded"), selectorText)
-					globalErrors = append(globalErrors, fmt.Sprintf(`Failed to wait for selector %s`, replacerSelector.Replace(selectorText)))
-				} else if err != nil {
-					log.Printf(red("[ Engine ] Failed to wait for selector %s, due to %v"), selectorText, err)
-					globalErrors = append(globalErrors, fmt.Sprintf(`Failed to wait for selector %s`, replacerSelector.Replace(selectorText)))
+			if errors.Is(err, context.DeadlineExceeded) {
+				log.Printf(red("[ Engine ] Failed to wait for selector %s, due to context deadline exceeded"), selectorText)
+				session.AddError(fmt.Sprintf(`Failed to wait for selector %s`, replacerSelector.Replace(selectorText)))
+			} else if err != nil {
+				log.Printf(red("[ Engine ] Failed to wait for selector %s, due to %v"), selectorText, err)
+				session.AddError(fmt.Sprintf(`Failed to wait for selector %s`, replacerSelector.Replace(selectorText)))
+			}
+
+		} else if IsCondition(flowData.If) {
+
+			branch := flowData.If.Else
+			session.stepPath = stepPath + ".else."
+
+			if Check(session, flowData.If, paginateIndex, currentItemIndex) {
+				branch = flowData.If.Then
+				session.stepPath = stepPath + ".then."
+			}
+
+			isFinish, branchContent := Parse(session, branch, 0, len(branch), paginateIndex, itemsOnPageLimit, pageContent)
+			pageContent = branchContent
+			session.stepPath = stepPrefix
+
+			if !isFinish {
+				return false, pageContent
+			}
+
+		} else if flowData.Follow.Selector != "" {
+
+			selectorText = Selector(session, flowData.Follow.Selector, paginateIndex, currentItemIndex)
+			session.stepPath = stepPath + ".follow."
+			isFinish, followContent := Follow(session, flowData.Follow, policy, Policy(session.Request, flowData, 10*time.Second), paginateIndex, itemsOnPageLimit, currentItemIndex)
+			session.stepPath = stepPrefix
+			resultContent = followContent
+
+			if !isFinish {
+				if len(resultContent.Items) > 0 {
+					pageContent = append(pageContent, resultContent)
 				}
 
-			} else if flowData.Element.Write != "" {
+				return false, pageContent
+			}
+
+		} else if flowData.ForEach.Selector != "" {
+
+			selectorText = Selector(session, flowData.ForEach.Selector, paginateIndex, currentItemIndex)
+			session.stepPath = stepPath + ".for_each."
+			isFinish, eachContent := ForEach(session, flowData.ForEach, policy, paginateIndex, itemsOnPageLimit, currentItemIndex)
+			session.stepPath = stepPrefix
+			resultContent = eachContent
+
+			if !isFinish {
+				if len(resultContent.Items) > 0 {
+					pageContent = append(pageContent, resultContent)
+				}
+
+				return false, pageContent
+			}
 
+		}
+
+		// Process with Element
+
+		if detectedElement != nil {
+
+			if flowData.Element.Write != "" {
+
+				// Environment variable is read only from the flow, never from the rendered value
 				if strings.Contains(flowData.Element.Write, "$") {
 					detectedElement.MustInput(os.Getenv(strings.ReplaceAll(flowData.Element.Write, "$", "")))
 				} else {
-					detectedElement.MustInput(flowData.Element.Write)
+					detectedElement.MustInput(session.Render(flowData.Element.Write))
 				}
 
 			} else if flowData.Element.Value != "" {
 
-				detectedElement.Eval("() => this.value = '" + flowData.Element.Value + "'")
+				detectedElement.Eval(`(value) => this.value = value`, session.Render(flowData.Element.Value))
 
 			} else if flowData.Element.Select != "" {
 
@@ -795,7 +1391,7 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 
 			} else if flowData.Capture.Name != "" {
 
-				capturePath := imagesDirectory + temporarySlugName + "-" + strconv.Itoa(paginateIndex) + "-" + flowData.Capture.Name + ".jpeg"
+				capturePath := imagesDirectory + session.SlugName + "-" + strconv.Itoa(paginateIndex) + "-" + flowData.Capture.Name + ".jpeg"
 				captureOptions := &proto.PageCaptureScreenshot{
 					Format:      proto.PageCaptureScreenshotFormatJpeg,
 					Quality:     lib.Int(100),
@@ -823,13 +1419,11 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 
 					if captureError != nil {
 						log.Printf(red("[ Engine ] Failed to capture missing element %s"), flowData.Capture.Selector)
-						globalErrors = append(globalErrors, fmt.Sprintf(`Failed to capture missing selector %s for %s`, replacerSelector.Replace(flowData.Capture.Selector), flowData.Capture.Name))
+						session.AddError(fmt.Sprintf(`Failed to capture missing selector %s for %s`, replacerSelector.Replace(flowData.Capture.Selector), flowData.Capture.Name))
 					}
 				}
 
-				pathReplaced := replacerPath.Replace(string(capturePath))
-
-				time.Sleep(1 * time.Second)
+				session.Sleep(1 * time.Second)
 
 				fileSize := 0
 
@@ -841,14 +1435,14 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 					fileSize = int(filePosition.Size())
 				}
 
-				diskUsage["images"] += float64(fileSize)
+				session.AddDisk("images", float64(fileSize))
 
 				resultContent.Type = "image"
 				resultContent.Length = fileSize
 				resultContent.Name = flowData.Capture.Name
 
 				if fileSize > 0 {
-					resultContent.Content = engineProxyURL + pathReplaced
+					resultContent.Content = Upload(session, capturePath)
 				} else {
 					resultContent.Content = ""
 				}
@@ -915,13 +1509,13 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 							sourceText = *source
 
 							if !strings.Contains(sourceText, "http") {
-								sourceTextScheme := strings.ReplaceAll(temporaryDomainName+"/"+sourceText, "//", "/")
+								sourceTextScheme := strings.ReplaceAll(session.DomainName+"/"+sourceText, "//", "/")
 								sourceText = strings.ReplaceAll(sourceTextScheme, "__SCHEME__", "://")
 							}
 						}
 
 						if flowData.Take.UseForNavigate {
-							temporaryNavigateUrl = sourceText
+							session.NavigateUrl = sourceText
 						}
 					}
 
@@ -933,7 +1527,7 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 
 				if flowData.Take.Parse == "ocr" {
 					captureError := rod.Try(func() {
-						file, errTemp := ioutil.TempFile("", "owl-ocr-"+pageId+".*.png")
+						file, errTemp := ioutil.TempFile("", "owl-ocr-"+session.Id+".*.png")
 
 						if errTemp != nil {
 							log.Fatal(errTemp)
@@ -959,8 +1553,12 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 						buf := new(bytes.Buffer)
 						buf.ReadFrom(file)
 
-						textDecoded := buf.String()
-						textNormalize := strings.ReplaceAll(strings.Replace(textDecoded, "\n", "<br>", -1), `"`, `“`)
+						textNormalize := buf.String()
+
+						// Legacy JSON could not carry newlines and quotes of the text
+						if session.Request.LegacyJson {
+							textNormalize = strings.ReplaceAll(strings.Replace(textNormalize, "\n", "<br>", -1), `"`, `“`)
+						}
 
 						resultContent.Type = "ocr"
 						resultContent.Length = len(textNormalize)
@@ -970,7 +1568,7 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 
 					if captureError != nil {
 						log.Printf(red("[ Engine ] Failed to capture element %v"), captureError)
-						globalErrors = append(globalErrors, fmt.Sprintf(`Failed to OCR selector %s for %s`, selectorText, fieldName))
+						session.AddError(fmt.Sprintf(`Failed to OCR selector %s for %s`, selectorText, fieldName))
 					}
 				}
 
@@ -1130,7 +1728,7 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 
 							if len(tableCellContent) > 0 && continueExtract {
 								if temporaryTableHyperlink && !strings.Contains(tableCellContent, "http") {
-									cellContentScheme := strings.ReplaceAll(temporaryDomainName+"/"+tableCellContent, "//", "/")
+									cellContentScheme := strings.ReplaceAll(session.DomainName+"/"+tableCellContent, "//", "/")
 									tableCellContent = strings.ReplaceAll(cellContentScheme, "__SCHEME__", "://")
 								}
 
@@ -1155,26 +1753,83 @@ func Parse(request types.Config, flow []types.Flow, current int, total int, page
 
 				}
 
-				jsonTable, _ := json.Marshal(types.ResultTable{
+				resultContent.Type = "table"
+				resultContent.Length = len(tableRow)
+				resultContent.Name = fieldName
+				resultContent.Table = &types.ResultTable{
 					Name:   flowData.Table.Name,
 					Column: tableHeaderCount,
 					Row:    tableRowCount - 1,
 					Header: tableHeader,
 					Data:   tableRow,
+				}
+			}
+		}
+
+		if resultContent.Content != "" || resultContent.Table != nil || len(resultContent.Items) > 0 {
+			pageContent = append(pageContent, resultContent)
+		}
+
+		if resultContent.Name != "" && resultContent.Content != "" {
+			session.Variables[resultContent.Name] = resultContent.Content
+		}
+
+		if flowData.Take.SaveAs != "" && resultContent.Content != "" {
+			session.Variables[flowData.Take.SaveAs] = resultContent.Content
+		}
+
+		stepEvent := types.StepEvent{
+			Index:    current,
+			Path:     stepPath,
+			Page:     paginateIndex + 1,
+			Kind:     StepKind(flowData),
+			Selector: selectorText,
+			Duration: time.Since(stepStart) / 1000000,
+			Error:    strings.Join(session.ErrorsSince(stepErrors), "; "),
+		}
+
+		if resultContent.Content != "" || resultContent.Table != nil || len(resultContent.Items) > 0 {
+			stepEvent.Content = &resultContent
+		}
+
+		metricStepDuration.WithLabelValues(stepEvent.Kind).Observe(time.Since(stepStart).Seconds())
+
+		session.Emit(stepEvent)
+
+		if Failed(flowData, resultContent, stepEvent) {
+			switch {
+			case flowData.Required || session.Request.OnError == types.OnErrorFail:
+				log.Printf(red("[ Engine ] Step %s failed, stopping the flow"), stepPath)
+
+				failureError := stepEvent.Error
+
+				if failureError == "" {
+					failureError = fmt.Sprintf(`Nothing found for selector %s`, replacerSelector.Replace(selectorText))
+				}
+
+				session.Fail(types.StepFailure{
+					Index:    current,
+					Path:     stepPath,
+					Page:     stepEvent.Page,
+					Kind:     stepEvent.Kind,
+					Selector: replacerSelector.Replace(selectorText),
+					Error:    failureError,
 				})
 
-				resultContent.Type = "table"
-				resultContent.Length = len(jsonTable)
-				resultContent.Name = fieldName
-				resultContent.Content = string(jsonTable)
+				return false, pageContent
+			case session.Request.OnError == types.OnErrorSkipItem:
+				log.Printf(yellow("[ Engine ] Step %s failed, skipping the item"), stepPath)
+
+				session.skipItem = true
 			}
 		}
 
-		if resultContent.Content != "" {
-			pageContent = append(pageContent, resultContent)
+		// Skipped item drops the remaining steps, the nested step skips the item of its parent as well
+		if session.skipItem {
+			return true, pageContent
 		}
 
-		return Parse(request, flow, current+1, total, page, pageId, paginateIndex, itemsOnPageLimit, pageContent, diskUsage)
+		return Parse(session, flow, current+1, total, paginateIndex, itemsOnPageLimit, pageContent)
 	}
 
 	if current == total {
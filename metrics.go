@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var metricFlowsStarted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "engine_flows_started_total",
+	Help: "Number of flows started by the engine.",
+})
+
+var metricFlowsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "engine_flows_finished_total",
+	Help: "Number of flows finished by the engine, by final status.",
+}, []string{"status"})
+
+var metricFlowDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "engine_flow_duration_seconds",
+	Help:    "Duration of the whole flow.",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+})
+
+var metricStepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "engine_step_duration_seconds",
+	Help:    "Duration of every executed flow step, by step kind.",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+}, []string{"kind"})
+
+var metricSelectorNotFound = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "engine_selector_not_found_total",
+	Help: "Number of selectors not found on the page.",
+})
+
+var metricNavigationTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "engine_navigation_timeouts_total",
+	Help: "Number of navigations stopped by the timeout.",
+})
+
+var metricStepRetries = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "engine_step_retries_total",
+	Help: "Number of retried element lookups, navigations and pagination clicks.",
+})
+
+var metricBandwidth = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "engine_bandwidth_bytes_total",
+	Help: "Bytes transferred by the browser, by resource type.",
+}, []string{"type"})
+
+var metricCompressionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "engine_recording_compression_seconds",
+	Help:    "Duration of the recording compression using FFmpeg.",
+	Buckets: prometheus.LinearBuckets(0.5, 0.5, 20),
+})
+
+var metricCompressionFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "engine_recording_compression_failures_total",
+	Help: "Number of recordings failed to compress.",
+})
+
+var metricPagesOpen = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "engine_browser_pages_open",
+	Help: "Number of browser pages opened by running flows.",
+})
+
+var metricRetentionReclaimed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "engine_retention_reclaimed_bytes_total",
+	Help: "Bytes of expired artifacts deleted by the retention.",
+})
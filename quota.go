@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"engine/lib"
+	"engine/types"
+)
+
+// Allowance is the run reserved for the API key, the usage of the run is added
+// into the shared usage of the key while it runs so concurrent runs share the quota
+type Allowance struct {
+	key       *types.ApiKey
+	counted   time.Time
+	bandwidth float64
+	disk      float64
+	stop      chan struct{}
+}
+
+var quotaUsages = make(map[string]*types.QuotaUsage)
+var quotaMutex sync.Mutex
+
+// How often the run time of the running sessions is added into the usage
+var quotaInterval = 10 * time.Second
+
+/**
+ * Function to reserve a run for the API key, the error code is returned when
+ * the daily quota is already used or too many runs are active
+ */
+func Acquire(key *types.ApiKey) (*Allowance, string, string) {
+	if key == nil {
+		return nil, "", ""
+	}
+
+	quotaMutex.Lock()
+	defer quotaMutex.Unlock()
+
+	usage := currentUsage(key)
+	quota := key.Quota
+
+	if quota.Concurrent > 0 && usage.Running >= quota.Concurrent {
+		return nil, types.QuotaConcurrentExceeded, fmt.Sprintf("API key %s already has %d active run(s)", key.Name, usage.Running)
+	}
+
+	if quota.Bandwidth > 0 && usage.Bandwidth >= quota.Bandwidth {
+		return nil, types.QuotaBandwidthExceeded, fmt.Sprintf("API key %s used the daily bandwidth quota of %.0f bytes", key.Name, quota.Bandwidth)
+	}
+
+	if quota.Disk > 0 && usage.Disk >= quota.Disk {
+		return nil, types.QuotaDiskExceeded, fmt.Sprintf("API key %s used the daily disk quota of %.0f bytes", key.Name, quota.Disk)
+	}
+
+	if quota.Minutes > 0 && usage.Minutes >= quota.Minutes {
+		return nil, types.QuotaMinutesExceeded, fmt.Sprintf("API key %s used the daily run time quota of %.0f minutes", key.Name, quota.Minutes)
+	}
+
+	usage.Running++
+
+	return &Allowance{key: key}, "", ""
+}
+
+/**
+ * Function to watch the usage of the running session and abort it when the quota
+ * of the key is exceeded, together with the other running sessions of the key
+ */
+func (allowance *Allowance) Watch(session *Session) {
+	if allowance == nil {
+		return
+	}
+
+	quotaMutex.Lock()
+	allowance.counted = time.Now()
+	allowance.stop = make(chan struct{})
+	quotaMutex.Unlock()
+
+	session.OnUsage = func(sessionUsage types.ResultUsage) {
+		allowance.update(session, sessionUsage)
+	}
+
+	if allowance.key.Quota.Minutes <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(quotaInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-allowance.stop:
+				return
+			case <-ticker.C:
+				allowance.update(session, session.Usage())
+			}
+		}
+	}()
+}
+
+// Adds the usage of the session into the usage of the key and aborts the session
+// when the key is over its quota
+func (allowance *Allowance) update(session *Session, sessionUsage types.ResultUsage) {
+	key := allowance.key
+	quota := key.Quota
+
+	quotaMutex.Lock()
+	usage := currentUsage(key)
+	allowance.count(usage, sessionUsage)
+	current := *usage
+	quotaMutex.Unlock()
+
+	if quota.Bandwidth > 0 && current.Bandwidth > quota.Bandwidth {
+		session.Abort(types.QuotaBandwidthExceeded, fmt.Sprintf("API key %s exceeded the daily bandwidth quota of %.0f bytes", key.Name, quota.Bandwidth))
+	}
+
+	if quota.Disk > 0 && current.Disk > quota.Disk {
+		session.Abort(types.QuotaDiskExceeded, fmt.Sprintf("API key %s exceeded the daily disk quota of %.0f bytes", key.Name, quota.Disk))
+	}
+
+	if quota.Minutes > 0 && current.Minutes > quota.Minutes {
+		session.Abort(types.QuotaMinutesExceeded, fmt.Sprintf("API key %s exceeded the daily run time quota of %.0f minutes", key.Name, quota.Minutes))
+	}
+}
+
+// Adds the usage of the session which is not counted yet, the caller holds the quota mutex
+func (allowance *Allowance) count(usage *types.QuotaUsage, sessionUsage types.ResultUsage) {
+	// Usage of the session only grows, the older report which arrives late is ignored
+	if bandwidth := Total(sessionUsage.Bandwidth); bandwidth > allowance.bandwidth {
+		usage.Bandwidth += bandwidth - allowance.bandwidth
+		allowance.bandwidth = bandwidth
+	}
+
+	if disk := Total(sessionUsage.Disk); disk > allowance.disk {
+		usage.Disk += disk - allowance.disk
+		allowance.disk = disk
+	}
+
+	if !allowance.counted.IsZero() {
+		now := time.Now()
+		usage.Minutes += now.Sub(allowance.counted).Minutes()
+		allowance.counted = now
+	}
+}
+
+/**
+ * Function to add the rest of the usage of the finished run and release the reserved run
+ */
+func (allowance *Allowance) Release(usage types.ResultUsage) {
+	if allowance == nil {
+		return
+	}
+
+	quotaMutex.Lock()
+	defer quotaMutex.Unlock()
+
+	current := currentUsage(allowance.key)
+	allowance.count(current, usage)
+	allowance.counted = time.Time{}
+
+	if allowance.stop != nil {
+		close(allowance.stop)
+		allowance.stop = nil
+	}
+
+	if current.Running > 0 {
+		current.Running--
+	}
+}
+
+// Returns the usage of the key for today, the usage is loaded from the run
+// history when the engine is restarted in the middle of the day
+func currentUsage(key *types.ApiKey) *types.QuotaUsage {
+	today := time.Now().Format("2006-01-02")
+	usage, exists := quotaUsages[key.Name]
+
+	if exists && usage.Day == today {
+		usage.Quota = key.Quota
+		return usage
+	}
+
+	running := 0
+
+	if exists {
+		running = usage.Running
+	}
+
+	usage = &types.QuotaUsage{
+		Key:     key.Name,
+		Day:     today,
+		Running: running,
+		Quota:   key.Quota,
+	}
+
+	if engineStore != nil && !exists {
+		midnight, _ := time.ParseInLocation("2006-01-02", today, time.Local)
+		runs, _ := engineStore.Find(types.RunFilter{Owner: key.Name, Since: midnight})
+
+		for _, run := range runs {
+			usage.Bandwidth += Total(run.Usage.Bandwidth)
+			usage.Disk += Total(run.Usage.Disk)
+			usage.Minutes += (run.Duration * time.Millisecond).Minutes()
+		}
+	}
+
+	quotaUsages[key.Name] = usage
+
+	return usage
+}
+
+/**
+ * Function to sum the usage map of every resource type
+ */
+func Total(usage map[string]float64) float64 {
+	total := 0.0
+
+	for _, size := range usage {
+		total += size
+	}
+
+	return total
+}
+
+/**
+ * Handle the quota usage API
+ *
+ * GET /usage  usage of the API key for today, admin key receives every key
+ */
+func Usage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		lib.JSON(w, http.StatusMethodNotAllowed, types.Result{Code: 405, Message: "Method not allowed for this request"})
+		return
+	}
+
+	requestKey := RequestKey(r)
+	list := make([]types.QuotaUsage, 0, len(engineKeys))
+
+	quotaMutex.Lock()
+
+	for index := range engineKeys {
+		key := &engineKeys[index]
+
+		if requestKey != nil && !requestKey.Permissions.Admin && requestKey.Name != key.Name {
+			continue
+		}
+
+		list = append(list, *currentUsage(key))
+	}
+
+	quotaMutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+
+	lib.JSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"engine/lib"
+	"engine/types"
+
+	"github.com/fatih/color"
+)
+
+var retentionPolicy types.Retention
+var retentionInterval time.Duration
+var retentionNext *time.Time
+var retentionLast *types.RetentionReport
+var retentionReclaimed float64
+var retentionMutex sync.Mutex
+var retentionSweep sync.Mutex
+
+/**
+ * Function to start the periodic cleanup of expired artifacts, the policy is
+ * read from RETENTION_MAX_AGE, RETENTION_MAX_BYTES and RETENTION_INTERVAL
+ */
+func Retention() {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	retentionPolicy = types.Retention{
+		MaxAge: os.Getenv(`RETENTION_MAX_AGE`),
+	}
+
+	retentionPolicy.MaxBytes, _ = strconv.ParseInt(os.Getenv(`RETENTION_MAX_BYTES`), 10, 64)
+
+	if _, errorAge := ParseAge(retentionPolicy.MaxAge); errorAge != nil {
+		panic(fmt.Sprintf("Invalid RETENTION_MAX_AGE %s, %v", retentionPolicy.MaxAge, errorAge))
+	}
+
+	retentionInterval = time.Hour
+
+	if interval := os.Getenv(`RETENTION_INTERVAL`); interval != "" {
+		parsedInterval, errorInterval := ParseAge(interval)
+
+		if errorInterval != nil || parsedInterval <= 0 {
+			panic(fmt.Sprintf("Invalid RETENTION_INTERVAL %s", interval))
+		}
+
+		retentionInterval = parsedInterval
+	}
+
+	if engineStore == nil {
+		log.Printf(red("[ Engine ] Retention is disabled, it needs the run history"))
+		return
+	}
+
+	log.Printf("%s Retention runs every %s, max age %q and max bytes %d", yellow("[ Engine ]"), retentionInterval, retentionPolicy.MaxAge, retentionPolicy.MaxBytes)
+
+	go func() {
+		for {
+			next := time.Now().Add(retentionInterval)
+
+			retentionMutex.Lock()
+			retentionNext = &next
+			retentionMutex.Unlock()
+
+			time.Sleep(time.Until(next))
+
+			if ShuttingDown() {
+				return
+			}
+
+			Sweep()
+		}
+	}()
+}
+
+/**
+ * Function to parse the max age of artifacts, days are written as 30d, the empty
+ * age is zero while the written age must be positive
+ */
+func ParseAge(age string) (time.Duration, error) {
+	if age == "" {
+		return 0, nil
+	}
+
+	var duration time.Duration
+
+	if strings.HasSuffix(age, "d") {
+		days, errorDays := strconv.ParseFloat(strings.TrimSuffix(age, "d"), 64)
+
+		if errorDays != nil {
+			return 0, errorDays
+		}
+
+		duration = time.Duration(days * float64(24*time.Hour))
+	} else {
+		parsed, errorParse := time.ParseDuration(age)
+
+		if errorParse != nil {
+			return 0, errorParse
+		}
+
+		duration = parsed
+	}
+
+	if duration <= 0 {
+		return 0, fmt.Errorf("age %s must be positive", age)
+	}
+
+	return duration, nil
+}
+
+/**
+ * Function to delete the artifacts of runs which are older than the max age or
+ * over the max bytes, the newest runs are kept first, flow retention overrides the engine policy
+ */
+func Sweep() types.RetentionReport {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	retentionSweep.Lock()
+	defer retentionSweep.Unlock()
+
+	report := types.RetentionReport{Started: time.Now()}
+
+	runs, errorFind := engineStore.Find(types.RunFilter{})
+
+	if errorFind != nil {
+		report.Errors = append(report.Errors, errorFind.Error())
+	}
+
+	flowBytes := make(map[string]float64)
+	totalBytes := 0.0
+	referenced := make(map[string]bool)
+
+	for _, run := range runs {
+		if run.Expired != nil || len(run.Artifacts) == 0 {
+			continue
+		}
+
+		for _, artifact := range run.Artifacts {
+			referenced[strings.TrimPrefix(artifact, "/resources/")] = true
+		}
+
+		maxAge, _ := ParseAge(retentionPolicy.MaxAge)
+
+		if run.Config.Retention.MaxAge != "" {
+			maxAge, _ = ParseAge(run.Config.Retention.MaxAge)
+		}
+
+		size := Total(run.Usage.Disk)
+		flowBytes[run.Name] += size
+		totalBytes += size
+
+		expired := maxAge > 0 && run.Finished.Before(report.Started.Add(-maxAge))
+		expired = expired || (run.Config.Retention.MaxBytes > 0 && flowBytes[run.Name] > float64(run.Config.Retention.MaxBytes))
+		expired = expired || (retentionPolicy.MaxBytes > 0 && totalBytes > float64(retentionPolicy.MaxBytes))
+
+		if !expired {
+			continue
+		}
+
+		// Expired run no longer counts into the kept bytes
+		flowBytes[run.Name] -= size
+		totalBytes -= size
+
+		report.Errors = append(report.Errors, Expire(run, size, &report)...)
+	}
+
+	if maxAge, _ := ParseAge(retentionPolicy.MaxAge); maxAge > 0 {
+		report.Errors = append(report.Errors, Orphans(report.Started.Add(-maxAge), referenced, &report)...)
+	}
+
+	report.Finished = time.Now()
+	report.Duration = report.Finished.Sub(report.Started) / 1000000
+
+	metricRetentionReclaimed.Add(report.Reclaimed)
+
+	retentionMutex.Lock()
+	retentionLast = &report
+	retentionReclaimed += report.Reclaimed
+	retentionMutex.Unlock()
+
+	if len(report.Errors) > 0 {
+		log.Printf(red("[ Engine ] Retention finished with %d error(s)"), len(report.Errors))
+	}
+
+	log.Printf("%s Retention removed %d artifact(s) of %d run(s) and %d orphaned file(s), reclaimed %.0f bytes", yellow("[ Engine ]"), report.Artifacts, report.Runs, report.Orphans, report.Reclaimed)
+
+	return report
+}
+
+/**
+ * Function to delete every artifact of the run and mark the run as expired in the history
+ */
+func Expire(run types.Run, size float64, report *types.RetentionReport) []string {
+	var errorList []string
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	failed := false
+
+	for _, artifact := range run.Artifacts {
+		// Runs recorded before the artifact storage keep the resources path
+		key := strings.TrimPrefix(artifact, "/resources/")
+
+		errorDelete := engineStorage.Delete(ctx, key)
+
+		if errorDelete != nil {
+			errorList = append(errorList, fmt.Sprintf("Failed to delete artifact %s of run #%s, %v", key, run.Id, errorDelete))
+			failed = true
+			continue
+		}
+
+		report.Artifacts++
+	}
+
+	// The run is expired on the next retention, deleting the same artifact again is not an error
+	if failed {
+		return errorList
+	}
+
+	now := time.Now()
+
+	run.Artifacts = nil
+	run.Expired = &now
+	run.Reclaimed = size
+
+	if errorSave := engineStore.Save(run); errorSave != nil {
+		errorList = append(errorList, fmt.Sprintf("Failed to update run #%s, %v", run.Id, errorSave))
+	}
+
+	report.Runs++
+	report.Reclaimed += size
+
+	return errorList
+}
+
+/**
+ * Function to delete the files of the resources directories which are older than the
+ * time and belong to no kept run, such as the files of the run which crashed before it was saved
+ */
+func Orphans(before time.Time, referenced map[string]bool, report *types.RetentionReport) []string {
+	var errorList []string
+
+	for _, directory := range []string{imagesDirectory, videoDirectory} {
+		errorWalk := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, errorEntry error) error {
+			if errorEntry != nil {
+				return errorEntry
+			}
+
+			// Hidden files are the temporary files of the readiness check
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				return nil
+			}
+
+			info, errorInfo := entry.Info()
+
+			if errorInfo != nil || !info.ModTime().Before(before) || referenced[ArtifactKey(path)] {
+				return nil
+			}
+
+			if errorRemove := os.Remove(path); errorRemove != nil {
+				errorList = append(errorList, fmt.Sprintf("Failed to delete orphaned file %s, %v", ArtifactKey(path), errorRemove))
+				return nil
+			}
+
+			report.Orphans++
+			report.Reclaimed += float64(info.Size())
+
+			return nil
+		})
+
+		if errorWalk != nil && !os.IsNotExist(errorWalk) {
+			errorList = append(errorList, fmt.Sprintf("Failed to read directory %s, %v", ArtifactKey(directory), errorWalk))
+		}
+	}
+
+	return errorList
+}
+
+/**
+ * Handle the retention API, only admin key is allowed when authentication is enabled
+ *
+ * GET  /retention  policy, next run and reclaimed space
+ * POST /retention  run the retention now and return the report
+ */
+func Retentions(w http.ResponseWriter, r *http.Request) {
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	if key := RequestKey(r); key != nil && !key.Permissions.Admin {
+		lib.JSON(w, http.StatusForbidden, types.Result{Code: 403, Message: "API key " + key.Name + " is not allowed to manage retention"})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		retentionMutex.Lock()
+
+		status := types.RetentionStatus{
+			Enabled:   engineStore != nil,
+			Policy:    retentionPolicy,
+			Interval:  retentionInterval.String(),
+			Next:      retentionNext,
+			Reclaimed: retentionReclaimed,
+			Last:      retentionLast,
+		}
+
+		retentionMutex.Unlock()
+
+		lib.JSON(w, http.StatusOK, status)
+	case "POST":
+		if engineStore == nil {
+			lib.JSON(w, http.StatusServiceUnavailable, types.Result{Code: 503, Message: "Retention needs the run history which is disabled on this engine"})
+			return
+		}
+
+		lib.JSON(w, http.StatusOK, Sweep())
+	default:
+		lib.JSON(w, http.StatusMethodNotAllowed, types.Result{Code: 405, Message: "Method not allowed for this request"})
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"engine/lib"
+	"engine/types"
+
+	"github.com/fatih/color"
+	"github.com/gosimple/slug"
+	"github.com/robfig/cron/v3"
+)
+
+type ScheduledFlow struct {
+	types.ScheduleStatus
+
+	config   types.Config
+	schedule cron.Schedule
+	location *time.Location
+	job      *Job
+	stop     chan struct{}
+}
+
+var schedules = make(map[string]*ScheduledFlow)
+var schedulesMutex sync.Mutex
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+/**
+ * Function to register every flow with schedule from the directory
+ */
+func Scheduler(directory string) {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	if directory == "" {
+		return
+	}
+
+	files, _ := filepath.Glob(filepath.Join(directory, "*.yml"))
+	registered := 0
+
+	for _, file := range files {
+		config, errorConfig := lib.ReadConfig(file)
+
+		if errorConfig != nil {
+			log.Printf(red("[ Engine ] Cannot read flow file %s, due to %v"), file, errorConfig)
+			continue
+		}
+
+		if config.Schedule.Cron == "" {
+			continue
+		}
+
+		_, errorRegister := RegisterSchedule(*config, file)
+
+		if errorRegister != nil {
+			log.Printf(red("[ Engine ] Cannot schedule flow file %s, due to %v"), file, errorRegister)
+			continue
+		}
+
+		registered++
+	}
+
+	log.Printf("%s Scheduler started with %d flow(s) from %s", yellow("[ Engine ]"), registered, directory)
+}
+
+/**
+ * Function to validate the schedule of the flow and start it, the flow with the same name
+ * is replaced when it is registered from the same source
+ */
+func RegisterSchedule(config types.Config, source string) (*ScheduledFlow, error) {
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	if config.Name == "" {
+		return nil, errors.New("scheduled flow needs a name")
+	}
+
+	if len(config.Flow) == 0 {
+		return nil, errors.New("scheduled flow is empty, nothing to run")
+	}
+
+	if errorValidate := Validate(config); errorValidate != nil {
+		return nil, errorValidate
+	}
+
+	location := time.Local
+
+	if config.Schedule.Timezone != "" {
+		loadedLocation, errorLocation := time.LoadLocation(config.Schedule.Timezone)
+
+		if errorLocation != nil {
+			return nil, fmt.Errorf("unknown timezone %s", config.Schedule.Timezone)
+		}
+
+		location = loadedLocation
+	}
+
+	schedule, errorParse := cronParser.Parse(config.Schedule.Cron)
+
+	if errorParse != nil {
+		return nil, fmt.Errorf("invalid cron expression %q, %v", config.Schedule.Cron, errorParse)
+	}
+
+	missed := config.Schedule.Missed
+
+	if missed == "" {
+		missed = types.MissedSkip
+	}
+
+	if missed != types.MissedSkip && missed != types.MissedCatchUp {
+		return nil, fmt.Errorf("missed policy must be %s or %s", types.MissedSkip, types.MissedCatchUp)
+	}
+
+	if config.Schedule.Jitter < 0 {
+		return nil, errors.New("jitter cannot be negative")
+	}
+
+	// Scheduled runs are charged to the owner key the same way as the runs it submits
+	if len(engineKeys) > 0 {
+		key := FindKey(config.Schedule.Owner)
+
+		if key == nil {
+			return nil, fmt.Errorf("schedule owner %q is not a configured API key", config.Schedule.Owner)
+		}
+
+		if errorAuthorize := Authorize(key, config); errorAuthorize != nil {
+			return nil, errorAuthorize
+		}
+	}
+
+	entry := &ScheduledFlow{
+		ScheduleStatus: types.ScheduleStatus{
+			Id:       slug.Make(config.Name),
+			Name:     config.Name,
+			Cron:     config.Schedule.Cron,
+			Timezone: location.String(),
+			Missed:   missed,
+			Jitter:   config.Schedule.Jitter,
+			Source:   source,
+			Owner:    config.Schedule.Owner,
+			Next:     schedule.Next(time.Now().In(location)),
+		},
+		config:   config,
+		schedule: schedule,
+		location: location,
+		stop:     make(chan struct{}),
+	}
+
+	schedulesMutex.Lock()
+
+	previous, exists := schedules[entry.Id]
+
+	// Only the source which registered the schedule may replace it
+	if exists && previous.Source != source {
+		schedulesMutex.Unlock()
+
+		return nil, fmt.Errorf("schedule %s is already registered from %s", entry.Id, previous.Source)
+	}
+
+	if exists {
+		close(previous.stop)
+
+		// Keep the overlap protection with the run started by the replaced schedule
+		entry.job = previous.job
+		entry.Last = previous.Last
+		entry.LastJob = previous.LastJob
+	}
+
+	schedules[entry.Id] = entry
+	schedulesMutex.Unlock()
+
+	go entry.loop()
+
+	log.Printf("%s Flow %s scheduled at %s (%s)", yellow("[ Engine ]"), config.Name, config.Schedule.Cron, location)
+
+	return entry, nil
+}
+
+/**
+ * Function to stop the schedule of the flow, the running job is not cancelled
+ */
+func UnregisterSchedule(id string) bool {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	entry, exists := schedules[id]
+
+	if !exists {
+		return false
+	}
+
+	close(entry.stop)
+	delete(schedules, id)
+
+	return true
+}
+
+/**
+ * Function to stop every schedule on shutdown, the running jobs are not cancelled
+ */
+func StopSchedules() {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	for id, entry := range schedules {
+		close(entry.stop)
+		delete(schedules, id)
+	}
+}
+
+/**
+ * Handle the scheduler API
+ *
+ * GET    /schedules       list of scheduled flows
+ * POST   /schedules       register the flow with schedule
+ * DELETE /schedules/{id}  remove the schedule of the flow
+ */
+func Schedules(w http.ResponseWriter, r *http.Request) {
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	if key := RequestKey(r); key != nil && !key.Permissions.Admin {
+		lib.JSON(w, http.StatusForbidden, types.Result{Code: 403, Message: "API key " + key.Name + " is not allowed to manage schedules"})
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/schedules"), "/")
+
+	switch {
+	case id == "" && r.Method == "GET":
+		lib.JSON(w, http.StatusOK, ScheduleList())
+	case id == "" && r.Method == "POST":
+		var request types.Config
+
+		errorDecodeRequest := json.NewDecoder(r.Body).Decode(&request)
+
+		if errorDecodeRequest != nil {
+			http.Error(w, errorDecodeRequest.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if request.Schedule.Cron == "" {
+			lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: "Schedule cron expression is required"})
+			return
+		}
+
+		if request.Schedule.Owner == "" {
+			request.Schedule.Owner = KeyName(RequestKey(r))
+		}
+
+		if errorInclude := Include(&request); errorInclude != nil {
+			lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: errorInclude.Error()})
+			return
+		}
+
+		entry, errorRegister := RegisterSchedule(request, "api")
+
+		if errorRegister != nil {
+			lib.JSON(w, http.StatusBadRequest, types.Result{Code: 400, Message: errorRegister.Error()})
+			return
+		}
+
+		lib.JSON(w, http.StatusCreated, entry.status())
+	case id != "" && r.Method == "DELETE":
+		if !UnregisterSchedule(id) {
+			lib.JSON(w, http.StatusNotFound, types.Result{Code: 404, Message: "Schedule not found for " + id})
+			return
+		}
+
+		lib.JSON(w, http.StatusOK, types.Result{Code: 200, Message: "Schedule removed for " + id})
+	default:
+		lib.JSON(w, http.StatusMethodNotAllowed, types.Result{Code: 405, Message: "Method not allowed for this request"})
+	}
+}
+
+/**
+ * Function to return status of every scheduled flow ordered by name
+ */
+func ScheduleList() []types.ScheduleStatus {
+	schedulesMutex.Lock()
+	entries := make([]*ScheduledFlow, 0, len(schedules))
+
+	for _, entry := range schedules {
+		entries = append(entries, entry)
+	}
+	schedulesMutex.Unlock()
+
+	list := make([]types.ScheduleStatus, 0, len(entries))
+
+	for _, entry := range entries {
+		list = append(list, entry.status())
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+func (entry *ScheduledFlow) status() types.ScheduleStatus {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	status := entry.ScheduleStatus
+	status.Running = entry.job != nil && entry.job.status().Finished == nil
+
+	return status
+}
+
+func (entry *ScheduledFlow) loop() {
+	from := time.Now()
+
+	if entry.Missed == types.MissedCatchUp && entry.missedSince(from) {
+		schedulesMutex.Lock()
+		entry.Pending = true
+		schedulesMutex.Unlock()
+	}
+
+	for {
+		schedulesMutex.Lock()
+		pending := entry.Pending
+		job := entry.job
+		schedulesMutex.Unlock()
+
+		var previousDone <-chan struct{}
+
+		if pending {
+			if job == nil || job.status().Finished != nil {
+				entry.fire()
+				continue
+			}
+
+			previousDone = job.done
+		}
+
+		next := entry.schedule.Next(from.In(entry.location))
+
+		// The engine was suspended past the scheduled time, missed runs are
+		// merged into a single catch up run instead of running every one of them
+		if next.Before(time.Now()) {
+			if entry.Missed == types.MissedCatchUp {
+				schedulesMutex.Lock()
+				entry.Pending = true
+				schedulesMutex.Unlock()
+			}
+
+			from = time.Now()
+			continue
+		}
+
+		schedulesMutex.Lock()
+		entry.Next = next
+		schedulesMutex.Unlock()
+
+		timer := time.NewTimer(time.Until(next) + entry.jitter())
+
+		select {
+		case <-entry.stop:
+			timer.Stop()
+			return
+		case <-previousDone:
+			timer.Stop()
+		case <-timer.C:
+			from = next
+			entry.fire()
+		}
+	}
+}
+
+// Checks whether the schedule was due while the engine was not running,
+// based on the last run of the flow in the history
+func (entry *ScheduledFlow) missedSince(now time.Time) bool {
+	if engineStore == nil {
+		return false
+	}
+
+	runs, errorFind := engineStore.Find(types.RunFilter{Name: entry.Name})
+
+	if errorFind != nil {
+		return false
+	}
+
+	for _, run := range runs {
+		if run.Name == entry.Name {
+			return entry.schedule.Next(run.Started.In(entry.location)).Before(now)
+		}
+	}
+
+	return false
+}
+
+func (entry *ScheduledFlow) jitter() time.Duration {
+	if entry.Jitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(entry.Jitter) * int64(time.Second)))
+}
+
+// Submits the scheduled flow into the job queue, unless the previous run is still in progress
+func (entry *ScheduledFlow) fire() {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	schedulesMutex.Lock()
+
+	if entry.job != nil && entry.job.status().Finished == nil {
+		if entry.Missed == types.MissedCatchUp {
+			entry.Pending = true
+			log.Printf("%s Flow %s is still running, next run will catch up after job #%s", yellow("[ Engine ]"), entry.Name, entry.job.Id)
+		} else {
+			entry.Skipped++
+			log.Printf("%s Flow %s is still running, skipped the scheduled run", yellow("[ Engine ]"), entry.Name)
+		}
+
+		schedulesMutex.Unlock()
+		return
+	}
+
+	schedulesMutex.Unlock()
+
+	allowance, quotaCode, quotaMessage := Acquire(FindKey(entry.Owner))
+
+	if quotaCode != "" {
+		log.Printf(red("[ Engine ] %s, skipped the scheduled run of %s"), quotaMessage, entry.Name)
+
+		schedulesMutex.Lock()
+		entry.Pending = false
+		entry.Skipped++
+		schedulesMutex.Unlock()
+		return
+	}
+
+	job := NewJob(entry.config)
+	job.owner = entry.Owner
+	job.allowance = allowance
+
+	if !Enqueue(job) {
+		allowance.Release(types.ResultUsage{})
+
+		log.Printf(red("[ Engine ] Job queue is full, skipped the scheduled run of %s"), entry.Name)
+
+		schedulesMutex.Lock()
+		entry.Pending = false
+		entry.Skipped++
+		schedulesMutex.Unlock()
+		return
+	}
+
+	now := time.Now()
+
+	schedulesMutex.Lock()
+	entry.job = job
+	entry.Pending = false
+	entry.Last = &now
+	entry.LastJob = job.Id
+	schedulesMutex.Unlock()
+
+	log.Printf("%s Scheduled flow %s queued as job #%s", yellow("[ Engine ]"), entry.Name, job.Id)
+}
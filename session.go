@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"engine/lib"
+	"engine/types"
+
+	"github.com/go-rod/rod"
+)
+
+// Session holds the state of a single flow run, so many flows can share the
+// engine browser without touching each other's wrapper, navigation or errors
+type Session struct {
+	Id      string
+	Owner   string
+	Context context.Context
+	Request types.Config
+	Page    *rod.Page
+	Header  types.Proxy
+
+	// Called after every executed step of the flow
+	OnStep func(event types.StepEvent)
+
+	// Called after the disk or bandwidth usage is changed
+	OnUsage func(usage types.ResultUsage)
+
+	SlugName       string
+	DomainName     string
+	NavigateUrl    string
+	WrapperElement string
+	InfiniteScroll int
+
+	// Number of follow steps which opened the page of this session
+	Depth int
+
+	// Variables of the flow with the last content of every take name and save_as,
+	// used in the templates and compared by the if step
+	Variables map[string]string
+
+	parent    *Session
+	stepPath  string
+	skipItem  bool
+	failure   *types.StepFailure
+	ownErrors []string
+	unset     map[string]bool
+	mutex     sync.Mutex
+	scopes    int
+	pages     []types.ResultPage
+	pageItems int
+	cancel    context.CancelFunc
+	abortCode string
+	abortText string
+	errors    []string
+	artifacts []string
+	disk      map[string]float64
+	bandwidth map[string]float64
+}
+
+/**
+ * Function to create a clean session for the requested flow
+ */
+func NewSession(parent context.Context, request types.Config, pageId string) *Session {
+	ctx, cancel := context.WithCancel(parent)
+
+	variables := make(map[string]string, len(request.Variables))
+
+	for name, value := range request.Variables {
+		variables[name] = value
+	}
+
+	return &Session{
+		Id:        pageId,
+		Context:   ctx,
+		Request:   request,
+		cancel:    cancel,
+		Variables: variables,
+		disk:      make(map[string]float64),
+		bandwidth: make(map[string]float64),
+	}
+}
+
+// Child creates the session of the page which is opened by the follow step, errors,
+// artifacts, usage and abort are shared with the parent while the wrapper, navigation
+// and variables belong to the child
+func (session *Session) Child(page *rod.Page) *Session {
+	child := &Session{
+		Id:         session.Id,
+		Owner:      session.Owner,
+		Context:    session.Context,
+		Request:    session.Request,
+		Page:       page,
+		Header:     session.Header,
+		SlugName:   session.SlugName,
+		DomainName: session.DomainName,
+		Depth:      session.Depth + 1,
+		Variables:  make(map[string]string, len(session.Variables)),
+		parent:     session,
+		stepPath:   session.stepPath,
+		cancel:     func() {},
+	}
+
+	for name, value := range session.Variables {
+		child.Variables[name] = value
+	}
+
+	return child
+}
+
+// Returns the session of the flow which keeps the shared state of every child session
+func (session *Session) root() *Session {
+	for session.parent != nil {
+		session = session.parent
+	}
+
+	return session
+}
+
+func (session *Session) Cancelled() bool {
+	return session.Context.Err() != nil
+}
+
+// Abort stops the session with the error code, the flow stops on the next
+// cancellation point the same way as cancelled by the client
+func (session *Session) Abort(code string, message string) {
+	session = session.root()
+
+	session.mutex.Lock()
+
+	if session.abortCode == "" {
+		session.abortCode = code
+		session.abortText = message
+	}
+
+	session.mutex.Unlock()
+
+	session.cancel()
+}
+
+// Fail stops the session with the failed step, only the first failure is kept
+func (session *Session) Fail(failure types.StepFailure) {
+	session = session.root()
+
+	session.mutex.Lock()
+
+	if session.failure == nil {
+		session.failure = &failure
+	}
+
+	session.mutex.Unlock()
+
+	session.Abort(types.StepFailed, fmt.Sprintf("Step %s failed, %s", failure.Path, failure.Error))
+}
+
+func (session *Session) Failure() *types.StepFailure {
+	session = session.root()
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.failure
+}
+
+func (session *Session) Aborted() (string, string) {
+	session = session.root()
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.abortCode, session.abortText
+}
+
+func (session *Session) Close() {
+	session.cancel()
+}
+
+// Sleep waits for the given duration and returns false when the session is
+// cancelled before the duration ends
+func (session *Session) Sleep(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-session.Context.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Render replaces the templates of the text with the variables of the flow, unlike
+// lib.Render the template of the variable which is not saved yet becomes empty and
+// is reported as an error once for every step
+func (session *Session) Render(text string) string {
+	return lib.Render(text, func(name string) (string, bool) {
+		value, exists := session.Variables[name]
+
+		if !exists && !session.unset[name] {
+			if session.unset == nil {
+				session.unset = make(map[string]bool)
+			}
+
+			session.unset[name] = true
+			session.AddError(fmt.Sprintf(`Variable %s is not set`, name))
+		}
+
+		return value, true
+	})
+}
+
+// AddError keeps the error in the session which added it and in the shared list
+// of the flow, so the step of the child session never sees the error of its sibling
+func (session *Session) AddError(message string) {
+	session.mutex.Lock()
+	session.ownErrors = append(session.ownErrors, message)
+	session.mutex.Unlock()
+
+	root := session.root()
+
+	root.mutex.Lock()
+	root.errors = append(root.errors, message)
+	root.mutex.Unlock()
+}
+
+// ErrorMark returns the number of errors added by this session, used with ErrorsSince
+func (session *Session) ErrorMark() int {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return len(session.ownErrors)
+}
+
+// ErrorsSince returns the errors added by this session after the mark
+func (session *Session) ErrorsSince(mark int) []string {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return append([]string(nil), session.ownErrors[mark:]...)
+}
+
+func (session *Session) Emit(event types.StepEvent) {
+	session = session.root()
+
+	if session.OnStep != nil {
+		session.OnStep(event)
+	}
+}
+
+func (session *Session) Errors() []string {
+	session = session.root()
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return append([]string(nil), session.errors...)
+}
+
+// KeepPages stores the pages collected so far, the result of the flow which crashed
+// before it returned is built from them
+func (session *Session) KeepPages(pages []types.ResultPage, itemsOnPage int) {
+	session = session.root()
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.pages = append([]types.ResultPage(nil), pages...)
+	session.pageItems = itemsOnPage
+}
+
+func (session *Session) Pages() ([]types.ResultPage, int) {
+	session = session.root()
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return append([]types.ResultPage(nil), session.pages...), session.pageItems
+}
+
+func (session *Session) AddArtifact(path string) {
+	session = session.root()
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.artifacts = append(session.artifacts, path)
+}
+
+func (session *Session) Artifacts() []string {
+	session = session.root()
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return append([]string(nil), session.artifacts...)
+}
+
+func (session *Session) AddDisk(kind string, size float64) {
+	session = session.root()
+
+	session.mutex.Lock()
+	session.disk[kind] += size
+	session.mutex.Unlock()
+
+	if session.OnUsage != nil {
+		session.OnUsage(session.Usage())
+	}
+}
+
+func (session *Session) AddBandwidth(kind string, size float64) {
+	session = session.root()
+
+	session.mutex.Lock()
+	session.bandwidth[kind] += size
+	session.mutex.Unlock()
+
+	if session.OnUsage != nil {
+		session.OnUsage(session.Usage())
+	}
+}
+
+func (session *Session) Usage() types.ResultUsage {
+	session = session.root()
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	usage := types.ResultUsage{
+		Disk:      make(map[string]float64, len(session.disk)),
+		Bandwidth: make(map[string]float64, len(session.bandwidth)),
+	}
+
+	for kind, size := range session.disk {
+		usage.Disk[kind] = size
+	}
+
+	for kind, size := range session.bandwidth {
+		usage.Bandwidth[kind] = size
+	}
+
+	return usage
+}
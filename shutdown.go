@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"engine/types"
+
+	"github.com/fatih/color"
+)
+
+var engineServer = &http.Server{}
+var engineStopped = make(chan struct{})
+var shutdownTimeout time.Duration
+
+var activeRuns = make(map[*Session]struct{})
+var activeMutex sync.Mutex
+var shuttingDown bool
+
+/**
+ * Function to register the running session, false is returned when the engine
+ * is shutting down and the run must not be started
+ */
+func Track(session *Session) bool {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+
+	if shuttingDown {
+		return false
+	}
+
+	activeRuns[session] = struct{}{}
+
+	return true
+}
+
+/**
+ * Function to remove the finished session from the active runs
+ */
+func Untrack(session *Session) {
+	activeMutex.Lock()
+	delete(activeRuns, session)
+	activeMutex.Unlock()
+}
+
+func ShuttingDown() bool {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+
+	return shuttingDown
+}
+
+func ActiveRuns() int {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+
+	return len(activeRuns)
+}
+
+/**
+ * Function to stop the engine gracefully, new runs are refused while the active
+ * flows get the timeout to finish, the remaining flows are stopped on their next
+ * cancellation point so the recording and the result are still kept
+ */
+func Shutdown(timeout time.Duration) {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	activeMutex.Lock()
+	shuttingDown = true
+	activeMutex.Unlock()
+
+	log.Printf("%s Shutting down, waiting up to %s for %d active flow(s)", yellow("[ Engine ]"), timeout, ActiveRuns())
+
+	StopSchedules()
+	CancelQueuedJobs()
+
+	drained := true
+
+	if !Drain(timeout) {
+		activeMutex.Lock()
+
+		log.Printf(red("[ Engine ] Shutdown timeout reached, stopping %d active flow(s)"), len(activeRuns))
+
+		for session := range activeRuns {
+			session.Abort(types.EngineShuttingDown, "The engine is shutting down before the flow finished")
+		}
+
+		activeMutex.Unlock()
+
+		// Give the stopped flows time to finalize the recording and save the result
+		if !Drain(15 * time.Second) {
+			log.Printf(red("[ Engine ] %d flow(s) did not stop in time"), ActiveRuns())
+
+			drained = false
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errorServer := engineServer.Shutdown(ctx)
+
+	if errorServer != nil {
+		log.Printf(red("[ Engine ] Server did not stop cleanly, due to %v"), errorServer)
+	}
+
+	// Flow which did not stop may still save its run, the store is released when the process exits
+	if engineStore != nil && drained {
+		engineStore.Close()
+	}
+
+	engineBrowser.Close()
+
+	log.Printf("%s Engine stopped\n\n", yellow("[ Engine ]"))
+}
+
+/**
+ * Function to wait until every active run is finished, false is returned when the timeout is reached
+ */
+func Drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for ActiveRuns() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return true
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"engine/types"
+
+	"github.com/fatih/color"
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/devices"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+/**
+ * Function to check whether the step is an if step, the step needs at least one check
+ */
+func IsCondition(condition types.Condition) bool {
+	return condition.Exists != "" || condition.NotExists != "" || condition.Selector != "" || condition.Url != "" || condition.Value != ""
+}
+
+/**
+ * Function to validate the if step, the then and else steps need a check and
+ * every comparison needs both of its fields, the error names the missing field
+ */
+func CheckCondition(condition types.Condition) error {
+	if condition.Matches != "" && condition.Selector == "" {
+		return errors.New("has if.matches but is missing if.selector")
+	}
+
+	if condition.Value != "" && condition.Equals == "" {
+		return errors.New("has if.value but is missing if.equals")
+	}
+
+	if condition.Equals != "" && condition.Value == "" {
+		return errors.New("has if.equals but is missing if.value")
+	}
+
+	if !IsCondition(condition) && (len(condition.Then) > 0 || len(condition.Else) > 0) {
+		return errors.New("has if.then or if.else but is missing if.exists, if.not_exists, if.selector, if.url or if.value")
+	}
+
+	return nil
+}
+
+/**
+ * Function to check the condition on the current page, every given check must pass,
+ * the selectors are scoped into the wrapper the same way as the other steps
+ */
+func Check(session *Session, condition types.Condition, paginateIndex int, itemIndex int) bool {
+	red := color.New(color.FgRed).SprintFunc()
+
+	page := session.Page
+
+	if condition.Exists != "" {
+		exists, _, errorHas := page.Has(Selector(session, condition.Exists, paginateIndex, itemIndex))
+
+		if errorHas != nil || !exists {
+			return false
+		}
+	}
+
+	if condition.NotExists != "" {
+		exists, _, errorHas := page.Has(Selector(session, condition.NotExists, paginateIndex, itemIndex))
+
+		if errorHas != nil || exists {
+			return false
+		}
+	}
+
+	if condition.Selector != "" {
+		exists, element, errorHas := page.Has(Selector(session, condition.Selector, paginateIndex, itemIndex))
+
+		if errorHas != nil || !exists {
+			return false
+		}
+
+		if condition.Matches != "" {
+			text := ""
+
+			errorText := rod.Try(func() {
+				text = element.MustText()
+			})
+
+			if errorText != nil || !match(session, condition.Matches, text) {
+				return false
+			}
+		}
+	}
+
+	if condition.Url != "" {
+		info, errorInfo := page.Info()
+
+		if errorInfo != nil {
+			log.Printf(red("[ Engine ] Failed to read the page URL, due to %v"), errorInfo)
+			return false
+		}
+
+		if !match(session, condition.Url, info.URL) {
+			return false
+		}
+	}
+
+	if condition.Value != "" && session.Variables[condition.Value] != session.Render(condition.Equals) {
+		return false
+	}
+
+	return true
+}
+
+// Invalid pattern never matches and is reported as the error of the step
+func match(session *Session, pattern string, text string) bool {
+	expression, errorCompile := regexp.Compile(pattern)
+
+	if errorCompile != nil {
+		session.AddError(fmt.Sprintf(`Invalid pattern %s, %v`, pattern, errorCompile))
+		return false
+	}
+
+	return expression.MatchString(text)
+}
+
+/**
+ * Function to run the steps for every element matching the selector, every element is
+ * marked with its own scope attribute which becomes the wrapper of the nested steps
+ */
+func ForEach(session *Session, forEach types.ForEach, lookup RetryPolicy, paginateIndex int, itemsOnPageLimit int, itemIndex int) (bool, types.ResultContent) {
+	resultContent := types.ResultContent{Type: "for_each"}
+
+	page := session.Page
+	selector := Selector(session, forEach.Selector, paginateIndex, itemIndex)
+
+	errorLookup := lookup.Run(session, "selector "+selector, func() error {
+		return rod.Try(func() { page.Timeout(lookup.Timeout).MustElement(selector) })
+	})
+
+	// Missing element is the end of the loop, not an error of the step
+	if errorLookup != nil {
+		return !session.Cancelled(), resultContent
+	}
+
+	elements, errorElements := page.Elements(selector)
+
+	if errorElements != nil {
+		session.AddError(fmt.Sprintf(`Failed to find selector %s for each element`, replacerSelector.Replace(selector)))
+		return !session.Cancelled(), resultContent
+	}
+
+	if forEach.Limit > 0 && len(elements) > forEach.Limit {
+		elements = elements[:forEach.Limit]
+	}
+
+	wrapper := session.WrapperElement
+	slugName := session.SlugName
+
+	defer func() {
+		session.WrapperElement = wrapper
+		session.SlugName = slugName
+	}()
+
+	for index, element := range elements {
+		session.scopes++
+		scope := session.Id + "-" + strconv.Itoa(session.scopes)
+
+		if _, errorScope := element.Eval(`(scope) => this.setAttribute('data-owl-scope', scope)`, scope); errorScope != nil {
+			session.AddError(fmt.Sprintf(`Failed to scope element %d of %s`, len(resultContent.Items)+1, replacerSelector.Replace(selector)))
+			continue
+		}
+
+		session.WrapperElement = `[data-owl-scope="` + scope + `"]`
+
+		// Capture of every element is written into its own file
+		session.SlugName = slugName + "-" + strconv.Itoa(index+1)
+
+		isFinish, itemContent := Parse(session, forEach.Steps, 0, len(forEach.Steps), paginateIndex, itemsOnPageLimit, []types.ResultContent{})
+
+		// Element with the failed step is dropped by on_error skip_item
+		if session.skipItem {
+			session.skipItem = false
+			continue
+		}
+
+		if len(itemContent) > 0 {
+			resultContent.Items = append(resultContent.Items, itemContent)
+			resultContent.Length = len(resultContent.Items)
+		}
+
+		if !isFinish {
+			return false, resultContent
+		}
+	}
+
+	return true, resultContent
+}
+
+/**
+ * Function to open the link of every anchor matching the selector in its own browser
+ * page and run the steps there, the pages are limited by the concurrency of the step
+ * and the depth of nested follow steps by the flow and MAX_FOLLOW_DEPTH, the lookup
+ * policy applies to the anchors and the navigate policy to every opened link
+ */
+func Follow(session *Session, follow types.Follow, lookup RetryPolicy, navigate RetryPolicy, paginateIndex int, itemsOnPageLimit int, itemIndex int) (bool, types.ResultContent) {
+	red := color.New(color.FgRed).SprintFunc()
+
+	resultContent := types.ResultContent{Type: "follow"}
+
+	page := session.Page
+	selector := Selector(session, follow.Selector, paginateIndex, itemIndex)
+
+	maximumDepth := Maximum(`MAX_FOLLOW_DEPTH`, 3)
+
+	if session.Request.FollowDepth > 0 && session.Request.FollowDepth < maximumDepth {
+		maximumDepth = session.Request.FollowDepth
+	}
+
+	if session.Depth >= maximumDepth {
+		session.AddError(fmt.Sprintf(`Maximum follow depth only %d, the links of %s are not followed`, maximumDepth, replacerSelector.Replace(selector)))
+		return true, resultContent
+	}
+
+	errorLookup := lookup.Run(session, "selector "+selector, func() error {
+		return rod.Try(func() { page.Timeout(lookup.Timeout).MustElement(selector) })
+	})
+
+	// Missing anchor means there is nothing to follow, not an error of the step
+	if errorLookup != nil {
+		return !session.Cancelled(), resultContent
+	}
+
+	elements, errorElements := page.Elements(selector)
+
+	if errorElements != nil {
+		session.AddError(fmt.Sprintf(`Failed to find selector %s for follow`, replacerSelector.Replace(selector)))
+		return !session.Cancelled(), resultContent
+	}
+
+	var links []string
+
+	followed := make(map[string]bool, len(elements))
+
+	for _, element := range elements {
+		if follow.Limit > 0 && len(links) >= follow.Limit {
+			break
+		}
+
+		// Property of the anchor is the absolute URL resolved by the browser
+		href, errorHref := element.Property("href")
+
+		if errorHref != nil || !strings.HasPrefix(href.String(), "http") {
+			continue
+		}
+
+		// Anchors of the same page such as the image and the title are followed once
+		link := strings.SplitN(href.String(), "#", 2)[0]
+
+		if followed[link] {
+			continue
+		}
+
+		followed[link] = true
+		links = append(links, link)
+	}
+
+	concurrency := follow.Concurrency
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if maximumConcurrency := Maximum(`MAX_FOLLOW_CONCURRENCY`, 4); concurrency > maximumConcurrency {
+		concurrency = maximumConcurrency
+	}
+
+	items := make([][]types.ResultContent, len(links))
+	slots := make(chan struct{}, concurrency)
+
+	var group sync.WaitGroup
+
+	for index, link := range links {
+		slots <- struct{}{}
+		group.Add(1)
+
+		go func(index int, link string) {
+			defer group.Done()
+			defer func() { <-slots }()
+
+			if session.Cancelled() {
+				return
+			}
+
+			errorFollow := rod.Try(func() {
+				items[index] = Visit(session, follow, navigate, link, index, paginateIndex, itemsOnPageLimit)
+			})
+
+			if errorFollow != nil && !session.Cancelled() {
+				log.Printf(red("[ Engine ] Failed to follow %s, due to %v"), link, errorFollow)
+				session.AddError(fmt.Sprintf(`Failed to follow %s, due to error on requested page`, link))
+			}
+		}(index, link)
+	}
+
+	group.Wait()
+
+	for _, item := range items {
+		if len(item) > 0 {
+			resultContent.Items = append(resultContent.Items, item)
+		}
+	}
+
+	resultContent.Length = len(resultContent.Items)
+
+	return !session.Cancelled(), resultContent
+}
+
+/**
+ * Function to open the followed link in a new browser page of the child session and return its content
+ */
+func Visit(session *Session, follow types.Follow, policy RetryPolicy, link string, index int, paginateIndex int, itemsOnPageLimit int) []types.ResultContent {
+	red := color.New(color.FgRed).SprintFunc()
+
+	tab := engineBrowser.MustPage()
+
+	metricPagesOpen.Inc()
+
+	defer metricPagesOpen.Dec()
+	defer tab.Close()
+
+	tab.MustEmulate(devices.Device{
+		Title:          "Laptop Desktop",
+		UserAgent:      session.Header.UserAgent.String,
+		AcceptLanguage: "en",
+	})
+
+	child := session.Child(tab.Context(session.Context))
+	child.SlugName = session.SlugName + "-" + strconv.Itoa(index+1)
+
+	go tab.EachEvent(func(e *proto.NetworkResponseReceived) {
+		child.AddBandwidth(strings.ToLower(string(e.Type)), e.Response.EncodedDataLength)
+	})()
+
+	var itemContent []types.ResultContent
+
+	if follow.Name != "" {
+		itemContent = append(itemContent, types.ResultContent{Type: "anchor", Length: len(link), Name: follow.Name, Content: link})
+	}
+
+	errorNavigate := policy.Run(child, "navigation to "+link, func() error {
+		return rod.Try(func() {
+			child.Page.Timeout(policy.Timeout).MustNavigate(link)
+			child.Page.WaitNavigation(proto.PageLifecycleEventNameNetworkIdle)
+			child.Page.MustWaitLoad()
+		})
+	})
+
+	if errors.Is(errorNavigate, context.DeadlineExceeded) {
+		metricNavigationTimeouts.Inc()
+		log.Printf(red("[ Engine ] Failed to navigate to %s, due to context deadline exceeded"), link)
+		child.AddError(fmt.Sprintf(`Failed to navigate to %s, due to context deadline exceeded`, link))
+		return itemContent
+	} else if errorNavigate != nil {
+		log.Printf(red("[ Engine ] Failed to navigate to %s, due to %v"), link, errorNavigate)
+		child.AddError(fmt.Sprintf(`Failed to navigate to %s, due to error on requested page`, link))
+		return itemContent
+	}
+
+	_, itemContent = Parse(child, follow.Steps, 0, len(follow.Steps), paginateIndex, itemsOnPageLimit, itemContent)
+
+	// Linked page with the failed step is dropped by on_error skip_item
+	if child.skipItem {
+		return nil
+	}
+
+	return itemContent
+}
+
+// Longest wait between two attempts of the step
+const maximumBackoff = 30 * time.Second
+
+// RetryPolicy is the timeout of every attempt of the step and the number of
+// retries after the failed attempt, the backoff is doubled on every retry
+// up to the maximum backoff
+type RetryPolicy struct {
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+}
+
+/**
+ * Function to return the retry policy of the step, the step overrides the flow
+ * defaults and the fallback timeout is used when neither is set
+ */
+func Policy(request types.Config, flowData types.Flow, fallback time.Duration) RetryPolicy {
+	policy := RetryPolicy{
+		Timeout: fallback,
+		Backoff: time.Second,
+	}
+
+	// Retries of the step are used even when zero, so the step may disable the flow retries
+	if request.Retries != nil {
+		policy.Retries = *request.Retries
+	}
+
+	if flowData.Retries != nil {
+		policy.Retries = *flowData.Retries
+	}
+
+	if request.Timeout > 0 {
+		policy.Timeout = time.Duration(request.Timeout) * time.Second
+	}
+
+	if flowData.Timeout > 0 {
+		policy.Timeout = time.Duration(flowData.Timeout) * time.Second
+	}
+
+	if request.RetryBackoff > 0 {
+		policy.Backoff = time.Duration(request.RetryBackoff) * time.Second
+	}
+
+	if flowData.RetryBackoff > 0 {
+		policy.Backoff = time.Duration(flowData.RetryBackoff) * time.Second
+	}
+
+	return policy
+}
+
+/**
+ * Function to run the action until it succeeds or the retries are used up, the
+ * error of the last attempt is returned
+ */
+func (policy RetryPolicy) Run(session *Session, name string, action func() error) error {
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	backoff := policy.Backoff
+
+	if backoff > maximumBackoff {
+		backoff = maximumBackoff
+	}
+
+	errorAction := action()
+
+	for attempt := 1; errorAction != nil && attempt <= policy.Retries; attempt++ {
+		log.Printf("%s Retrying %s in %s, attempt %d of %d", yellow("[ Engine ]"), name, backoff, attempt, policy.Retries)
+
+		if session.Cancelled() || !session.Sleep(backoff) {
+			return errorAction
+		}
+
+		metricStepRetries.Inc()
+
+		errorAction = action()
+
+		if backoff *= 2; backoff > maximumBackoff {
+			backoff = maximumBackoff
+		}
+	}
+
+	return errorAction
+}
+
+/**
+ * Function to read the engine maximum from the environment, the fallback is used when it is not set
+ */
+func Maximum(name string, fallback int) int {
+	maximum, errorMaximum := strconv.Atoi(os.Getenv(name))
+
+	if errorMaximum != nil || maximum < 1 {
+		return fallback
+	}
+
+	return maximum
+}
+
+/**
+ * Function to visit every step of the flow including the nested steps,
+ * the path of the nested step is written as 2.then.0
+ */
+func Walk(flow []types.Flow, prefix string, visit func(path string, flowData types.Flow) error) error {
+	for index, flowData := range flow {
+		path := prefix + strconv.Itoa(index)
+
+		if errorVisit := visit(path, flowData); errorVisit != nil {
+			return errorVisit
+		}
+
+		if errorThen := Walk(flowData.If.Then, path+".then.", visit); errorThen != nil {
+			return errorThen
+		}
+
+		if errorElse := Walk(flowData.If.Else, path+".else.", visit); errorElse != nil {
+			return errorElse
+		}
+
+		if errorEach := Walk(flowData.ForEach.Steps, path+".for_each.", visit); errorEach != nil {
+			return errorEach
+		}
+
+		if errorFollow := Walk(flowData.Follow.Steps, path+".follow.", visit); errorFollow != nil {
+			return errorFollow
+		}
+	}
+
+	return nil
+}
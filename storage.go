@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"engine/lib"
+	"engine/lib/storage"
+	"engine/types"
+
+	"github.com/fatih/color"
+)
+
+var engineStorage storage.Storage
+var artifactSecret []byte
+
+/**
+ * Function to open the artifact storage, artifacts are kept in the resources
+ * directory unless STORAGE=s3 is configured
+ */
+func OpenStorage() {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	artifactSecret = []byte(os.Getenv(`ARTIFACT_SECRET`))
+
+	if len(artifactSecret) == 0 {
+		artifactSecret = make([]byte, 32)
+
+		if _, errorRandom := rand.Read(artifactSecret); errorRandom != nil {
+			panic(fmt.Sprintf("Failed to generate the artifact secret, %v", errorRandom))
+		}
+
+		log.Printf(red("[ Engine ] ARTIFACT_SECRET is not set, artifact links stop working when the engine is restarted"))
+	}
+
+	ttl, errorTtl := ParseAge(os.Getenv(`ARTIFACT_URL_TTL`))
+
+	if errorTtl != nil {
+		panic(fmt.Sprintf("Invalid ARTIFACT_URL_TTL %s, %v", os.Getenv(`ARTIFACT_URL_TTL`), errorTtl))
+	}
+
+	if ttl <= 0 {
+		ttl = 7 * 24 * time.Hour
+	}
+
+	switch os.Getenv(`STORAGE`) {
+	case "", "local":
+		engineStorage = storage.NewLocal(filepath.Clean(resourcesDirectory), enginePublicURL+"/artifacts/", artifactSecret, ttl)
+	case "s3":
+		bucket := os.Getenv(`S3_BUCKET`)
+
+		if bucket == "" {
+			bucket = "engine"
+		}
+
+		s3Storage, errorStorage := storage.NewS3(
+			os.Getenv(`S3_ENDPOINT`),
+			os.Getenv(`S3_ACCESS_KEY`),
+			os.Getenv(`S3_SECRET_KEY`),
+			bucket,
+			os.Getenv(`S3_REGION`),
+			os.Getenv(`S3_SECURE`) != "false",
+			os.Getenv(`S3_PUBLIC_URL`),
+			ttl,
+		)
+
+		if errorStorage != nil {
+			panic(fmt.Sprintf("Cannot open S3 storage %s, %v", os.Getenv(`S3_ENDPOINT`), errorStorage))
+		}
+
+		engineStorage = s3Storage
+	default:
+		panic(fmt.Sprintf("Unknown storage %s, use local or s3", os.Getenv(`STORAGE`)))
+	}
+
+	log.Printf("%s Using %s artifact storage", yellow("[ Engine ]"), engineStorage.Name())
+}
+
+/**
+ * Function to return the storage key of the file inside the resources directory
+ */
+func ArtifactKey(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), filepath.ToSlash(filepath.Clean(resourcesDirectory))+"/")
+}
+
+/**
+ * Function to store the artifact of the session and return its URL, empty when the upload failed
+ */
+func Upload(session *Session, path string) string {
+	red := color.New(color.FgRed).SprintFunc()
+
+	key := ArtifactKey(path)
+
+	// The upload is not stopped by the cancelled flow, the partial result still refers to the artifact
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	url, errorPut := engineStorage.Put(ctx, key, path)
+
+	if errorPut != nil {
+		log.Printf(red("[ Engine ] Failed to store artifact %s, due to %v"), key, errorPut)
+		session.AddError(fmt.Sprintf(`Failed to store artifact %s`, key))
+		return ""
+	}
+
+	session.AddArtifact(key)
+
+	return url
+}
+
+/**
+ * Handle the artifact download, the artifact is served only on the signed URL
+ * which is not expired yet and directories are never listed
+ *
+ * GET /artifacts/{key}?expires=&signature=
+ */
+func Artifacts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		lib.JSON(w, http.StatusMethodNotAllowed, types.Result{Code: 405, Message: "Method not allowed for this request"})
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/artifacts/")
+	query := r.URL.Query()
+
+	if !lib.VerifyArtifact(artifactSecret, key, query.Get("expires"), query.Get("signature")) {
+		lib.JSON(w, http.StatusForbidden, types.Result{Code: 403, Message: "Artifact link is invalid or expired"})
+		return
+	}
+
+	file, errorOpen := os.Open(filepath.Join(resourcesDirectory, filepath.FromSlash(path.Clean("/"+key))))
+
+	if errorOpen != nil {
+		lib.JSON(w, http.StatusNotFound, types.Result{Code: 404, Message: "Artifact not found for " + key})
+		return
+	}
+
+	defer file.Close()
+
+	info, errorStat := file.Stat()
+
+	if errorStat != nil || info.IsDir() {
+		lib.JSON(w, http.StatusNotFound, types.Result{Code: 404, Message: "Artifact not found for " + key})
+		return
+	}
+
+	// The link must not be cached after it is expired
+	expires, _ := strconv.ParseInt(query.Get("expires"), 10, 64)
+	maxAge := expires - time.Now().Unix()
+
+	if maxAge > 3600 {
+		maxAge = 3600
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
@@ -0,0 +1,45 @@
+package types
+
+type ApiKey struct {
+	Name        string      `yaml:"name" json:"name"`
+	Key         string      `yaml:"key" json:"-"`
+	Origins     []string    `yaml:"origins" json:"origins"`
+	Permissions Permissions `yaml:"permissions" json:"permissions"`
+	Quota       Quota       `yaml:"quota" json:"quota"`
+}
+
+type Permissions struct {
+	Env      bool `yaml:"env" json:"env"`
+	Record   bool `yaml:"record" json:"record"`
+	Evaluate bool `yaml:"evaluate" json:"evaluate"`
+	Admin    bool `yaml:"admin" json:"admin"`
+}
+
+type KeysConfig struct {
+	Keys []ApiKey `yaml:"keys" json:"keys"`
+}
+
+const (
+	QuotaBandwidthExceeded  = "quota_bandwidth_exceeded"
+	QuotaDiskExceeded       = "quota_disk_exceeded"
+	QuotaMinutesExceeded    = "quota_minutes_exceeded"
+	QuotaConcurrentExceeded = "quota_concurrent_exceeded"
+)
+
+// Quota limits the usage of the API key for a day, zero means unlimited
+type Quota struct {
+	Bandwidth  float64 `yaml:"bandwidth" json:"bandwidth"`
+	Disk       float64 `yaml:"disk" json:"disk"`
+	Minutes    float64 `yaml:"minutes" json:"minutes"`
+	Concurrent int     `yaml:"concurrent" json:"concurrent"`
+}
+
+type QuotaUsage struct {
+	Key       string  `json:"key"`
+	Day       string  `json:"day"`
+	Bandwidth float64 `json:"bandwidth"`
+	Disk      float64 `json:"disk"`
+	Minutes   float64 `json:"minutes"`
+	Running   int     `json:"running"`
+	Quota     Quota   `json:"quota"`
+}
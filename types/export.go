@@ -0,0 +1,16 @@
+package types
+
+const (
+	OutputJson   = "json"
+	OutputCsv    = "csv"
+	OutputNdjson = "ndjson"
+	OutputXlsx   = "xlsx"
+)
+
+// ResultSheet is a flat table of the result, records of the flow or rows of
+// a table step, which can be loaded into spreadsheet
+type ResultSheet struct {
+	Name    string     `json:"name"`
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
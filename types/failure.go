@@ -0,0 +1,24 @@
+package types
+
+const (
+	// Failed step is logged and the flow keeps going
+	OnErrorContinue = "continue"
+
+	// Failed step stops the flow the same way as the required step
+	OnErrorFail = "fail"
+
+	// Failed step drops the item, the flow continues with the next item
+	OnErrorSkipItem = "skip_item"
+)
+
+const StepFailed = "step_failed"
+
+// StepFailure is the step which stopped the flow, nested step is addressed by the path such as 2.for_each.0
+type StepFailure struct {
+	Index    int    `json:"index"`
+	Path     string `json:"path"`
+	Page     int    `json:"page"`
+	Kind     string `json:"kind"`
+	Selector string `json:"selector,omitempty"`
+	Error    string `json:"error"`
+}
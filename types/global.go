@@ -23,25 +23,31 @@ type UserAgent struct {
 }
 
 type Result struct {
-	Id             string        `json:"id,omitempty"`
-	Code           int           `json:"code"`
-	Name           string        `json:"name,omitempty"`
-	Slug           string        `json:"slug,omitempty"`
-	Proxy          string        `json:"proxy,omitempty"`
-	Message        string        `json:"message,omitempty"`
-	Duration       time.Duration `json:"duration,omitempty"`
-	Engine         string        `json:"engine,omitempty"`
-	FirstPage      string        `json:"first_page,omitempty"`
-	ItemsOnPage    int           `json:"items_on_page"`
-	Infinite       bool          `json:"infinite"`
-	InfiniteScroll int           `json:"infinite_scroll"`
-	Paginate       bool          `json:"paginate"`
-	PaginateLimit  int           `json:"paginate_limit"`
-	Record         bool          `json:"record"`
-	Recording      string        `json:"recording,omitempty"`
-	Result         []ResultPage  `json:"result,omitempty"`
-	Usage          ResultUsage   `json:"usage,omitempty"`
-	Errors         []string      `json:"errors,omitempty"`
+	Id             string           `json:"id,omitempty"`
+	Code           int              `json:"code"`
+	Name           string           `json:"name,omitempty"`
+	Slug           string           `json:"slug,omitempty"`
+	Proxy          string           `json:"proxy,omitempty"`
+	Message        string           `json:"message,omitempty"`
+	ErrorCode      string           `json:"error_code,omitempty"`
+	Duration       time.Duration    `json:"duration,omitempty"`
+	Engine         string           `json:"engine,omitempty"`
+	FirstPage      string           `json:"first_page,omitempty"`
+	ItemsOnPage    int              `json:"items_on_page"`
+	Infinite       bool             `json:"infinite"`
+	InfiniteScroll int              `json:"infinite_scroll"`
+	Paginate       bool             `json:"paginate"`
+	PaginateLimit  int              `json:"paginate_limit"`
+	Record         bool             `json:"record"`
+	Recording      string           `json:"recording,omitempty"`
+	Cancelled      bool             `json:"cancelled,omitempty"`
+	Version        int              `json:"version,omitempty"`
+	Result         []ResultPage     `json:"result,omitempty"`
+	Pages          []ResultPageLoad `json:"pages,omitempty"`
+	Records        []ResultItem     `json:"records,omitempty"`
+	Usage          ResultUsage      `json:"usage,omitempty"`
+	Errors         []string         `json:"errors,omitempty"`
+	Failure        *StepFailure     `json:"failure,omitempty"`
 }
 
 type ResultPage struct {
@@ -53,10 +59,12 @@ type ResultPage struct {
 }
 
 type ResultContent struct {
-	Type    string `json:"type,omitempty"`
-	Length  int    `json:"length"`
-	Name    string `json:"name,omitempty"`
-	Content string `json:"content,omitempty"`
+	Type    string            `json:"type,omitempty"`
+	Length  int               `json:"length"`
+	Name    string            `json:"name,omitempty"`
+	Content string            `json:"content,omitempty"`
+	Table   *ResultTable      `json:"table,omitempty"`
+	Items   [][]ResultContent `json:"items,omitempty"`
 }
 
 type ResultUsage struct {
@@ -87,32 +95,92 @@ type ResultTableData struct {
 }
 
 type Config struct {
-	Name           string `yaml:"name" json:"name"`
-	Engine         string `yaml:"engine" json:"engine"`
-	FirstPage      string `yaml:"first_page" json:"first_page"`
-	ItemsOnPage    int    `yaml:"items_on_page" json:"items_on_page"`
-	Infinite       bool   `yaml:"infinite" json:"infinite"`
-	InfiniteScroll int    `yaml:"infinite_scroll" json:"infinite_scroll"`
-	Paginate       bool   `yaml:"paginate" json:"paginate"`
-	PaginateButton string `yaml:"paginate_button" json:"paginate_button"`
-	PaginateLimit  int    `yaml:"paginate_limit" json:"paginate_limit"`
-	Proxy          bool   `yaml:"proxy" json:"proxy"`
-	ProxyCountry   string `yaml:"proxy_country" json:"proxy_country"`
-	Record         bool   `yaml:"record" json:"record"`
-	Flow           []Flow `yaml:"flow" json:"flow"`
+	Name           string            `yaml:"name" json:"name"`
+	Engine         string            `yaml:"engine" json:"engine"`
+	FirstPage      string            `yaml:"first_page" json:"first_page"`
+	ItemsOnPage    int               `yaml:"items_on_page" json:"items_on_page"`
+	Infinite       bool              `yaml:"infinite" json:"infinite"`
+	InfiniteScroll int               `yaml:"infinite_scroll" json:"infinite_scroll"`
+	Paginate       bool              `yaml:"paginate" json:"paginate"`
+	PaginateButton string            `yaml:"paginate_button" json:"paginate_button"`
+	PaginateLimit  int               `yaml:"paginate_limit" json:"paginate_limit"`
+	Proxy          bool              `yaml:"proxy" json:"proxy"`
+	ProxyCountry   string            `yaml:"proxy_country" json:"proxy_country"`
+	Record         bool              `yaml:"record" json:"record"`
+	LegacyJson     bool              `yaml:"legacy_json" json:"legacy_json"`
+	Output         string            `yaml:"output" json:"output"`
+	ResultVersion  int               `yaml:"result_version" json:"result_version"`
+	Webhook        Webhook           `yaml:"webhook" json:"webhook"`
+	Schedule       Schedule          `yaml:"schedule" json:"schedule"`
+	Retention      Retention         `yaml:"retention" json:"retention"`
+	Variables      map[string]string `yaml:"variables" json:"variables"`
+	FollowDepth    int               `yaml:"follow_depth" json:"follow_depth"`
+	Steps          map[string]Steps  `yaml:"steps" json:"steps"`
+	Timeout        int               `yaml:"timeout" json:"timeout"`
+	Retries        *int              `yaml:"retries" json:"retries"`
+	RetryBackoff   int               `yaml:"retry_backoff" json:"retry_backoff"`
+	OnError        string            `yaml:"on_error" json:"on_error"`
+	Flow           []Flow            `yaml:"flow" json:"flow"`
 }
 
 type Flow struct {
-	Element        Element `yaml:"element" json:"element"`
-	Take           Take    `yaml:"take" json:"take"`
-	Navigate       bool    `yaml:"navigate" json:"navigate"`
-	BackToPrevious bool    `yaml:"back_to_previous" json:"back_to_previous"`
-	WaitFor        WaitFor `yaml:"wait_for" json:"wait_for"`
-	Delay          int     `yaml:"delay" json:"delay"`
-	Scroll         int     `yaml:"scroll" json:"scroll"`
-	Wrapper        string  `yaml:"wrapper" json:"wrapper"`
-	Capture        Capture `yaml:"capture" json:"capture"`
-	Table          Table   `yaml:"table" json:"table"`
+	Element        Element           `yaml:"element" json:"element"`
+	Take           Take              `yaml:"take" json:"take"`
+	Navigate       bool              `yaml:"navigate" json:"navigate"`
+	Url            string            `yaml:"url" json:"url"`
+	BackToPrevious bool              `yaml:"back_to_previous" json:"back_to_previous"`
+	WaitFor        WaitFor           `yaml:"wait_for" json:"wait_for"`
+	Delay          int               `yaml:"delay" json:"delay"`
+	Scroll         int               `yaml:"scroll" json:"scroll"`
+	Wrapper        string            `yaml:"wrapper" json:"wrapper"`
+	Capture        Capture           `yaml:"capture" json:"capture"`
+	Table          Table             `yaml:"table" json:"table"`
+	If             Condition         `yaml:"if" json:"if"`
+	ForEach        ForEach           `yaml:"for_each" json:"for_each"`
+	Follow         Follow            `yaml:"follow" json:"follow"`
+	Include        string            `yaml:"include" json:"include"`
+	Use            string            `yaml:"use" json:"use"`
+	With           map[string]string `yaml:"with" json:"with"`
+	Timeout        int               `yaml:"timeout" json:"timeout"`
+	Retries        *int              `yaml:"retries" json:"retries"`
+	RetryBackoff   int               `yaml:"retry_backoff" json:"retry_backoff"`
+	Required       bool              `yaml:"required" json:"required"`
+}
+
+// Steps is the named block of steps which the use step runs with the params
+type Steps struct {
+	Params []string `yaml:"params" json:"params"`
+	Flow   []Flow   `yaml:"flow" json:"flow"`
+}
+
+// Condition runs the then steps when every given check passes, otherwise the else steps
+type Condition struct {
+	Exists    string `yaml:"exists" json:"exists"`
+	NotExists string `yaml:"not_exists" json:"not_exists"`
+	Selector  string `yaml:"selector" json:"selector"`
+	Matches   string `yaml:"matches" json:"matches"`
+	Url       string `yaml:"url" json:"url"`
+	Value     string `yaml:"value" json:"value"`
+	Equals    string `yaml:"equals" json:"equals"`
+	Then      []Flow `yaml:"then" json:"then"`
+	Else      []Flow `yaml:"else" json:"else"`
+}
+
+// ForEach runs the steps scoped to every element matching the selector, limit 0 runs all of them
+type ForEach struct {
+	Selector string `yaml:"selector" json:"selector"`
+	Limit    int    `yaml:"limit" json:"limit"`
+	Steps    []Flow `yaml:"steps" json:"steps"`
+}
+
+// Follow opens the link of every anchor matching the selector and runs the steps on the
+// linked page, the content of every page is merged into the item of its anchor
+type Follow struct {
+	Selector    string `yaml:"selector" json:"selector"`
+	Name        string `yaml:"name" json:"name"`
+	Limit       int    `yaml:"limit" json:"limit"`
+	Concurrency int    `yaml:"concurrency" json:"concurrency"`
+	Steps       []Flow `yaml:"steps" json:"steps"`
 }
 
 type Element struct {
@@ -131,6 +199,7 @@ type Take struct {
 	Contains       Contains `yaml:"contains" json:"contains"`
 	Parse          string   `yaml:"parse" json:"parse"`
 	UseForNavigate bool     `yaml:"use_for_navigate" json:"use_for_navigate"`
+	SaveAs         string   `yaml:"save_as" json:"save_as"`
 }
 
 type Contains struct {
@@ -0,0 +1,27 @@
+package types
+
+import "time"
+
+// Abort code of the flow stopped by the engine shutdown
+const EngineShuttingDown = "engine_shutting_down"
+
+type Health struct {
+	Status  string        `json:"status"`
+	Started time.Time     `json:"started"`
+	Uptime  time.Duration `json:"uptime"`
+}
+
+type Readiness struct {
+	Status   string           `json:"status"`
+	InFlight int64            `json:"in_flight"`
+	Queued   int              `json:"queued"`
+	Checks   []ReadinessCheck `json:"checks"`
+}
+
+type ReadinessCheck struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Version  string        `json:"version,omitempty"`
+	Message  string        `json:"message,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
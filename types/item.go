@@ -0,0 +1,118 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// Every item of the flow is a page with the list of content
+	ResultVersionContent = 1
+
+	// Every page load contains the items with take name to value
+	ResultVersionItems = 2
+)
+
+type ResultPageLoad struct {
+	Title    string        `json:"title,omitempty"`
+	Url      string        `json:"url,omitempty"`
+	Page     int           `json:"page"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Items    []ResultItem  `json:"items"`
+}
+
+// ResultItem is a single scraped item, the take name to the typed value which
+// keeps the order of the flow steps when it is encoded
+type ResultItem struct {
+	Names  []string
+	Values map[string]interface{}
+}
+
+func (item ResultItem) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("{")
+
+	for index, name := range item.Names {
+		if index > 0 {
+			buffer.WriteString(",")
+		}
+
+		key, errorKey := json.Marshal(name)
+
+		if errorKey != nil {
+			return nil, errorKey
+		}
+
+		value, errorValue := json.Marshal(item.Values[name])
+
+		if errorValue != nil {
+			return nil, errorValue
+		}
+
+		buffer.Write(key)
+		buffer.WriteString(":")
+		buffer.Write(value)
+	}
+
+	buffer.WriteString("}")
+
+	return buffer.Bytes(), nil
+}
+
+// Decodes the item in the same order, table value is decoded back into ResultTable
+func (item *ResultItem) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	if _, errorToken := decoder.Token(); errorToken != nil {
+		return errorToken
+	}
+
+	item.Names = nil
+	item.Values = make(map[string]interface{})
+
+	for decoder.More() {
+		token, errorToken := decoder.Token()
+
+		if errorToken != nil {
+			return errorToken
+		}
+
+		name, _ := token.(string)
+
+		var raw json.RawMessage
+
+		if errorDecode := decoder.Decode(&raw); errorDecode != nil {
+			return errorDecode
+		}
+
+		item.Names = append(item.Names, name)
+		item.Values[name] = decodeValue(raw)
+	}
+
+	_, errorToken := decoder.Token()
+
+	return errorToken
+}
+
+func decodeValue(raw json.RawMessage) interface{} {
+	var shape struct {
+		Header *json.RawMessage `json:"header"`
+		Data   *json.RawMessage `json:"data"`
+	}
+
+	if json.Unmarshal(raw, &shape) == nil && shape.Header != nil && shape.Data != nil {
+		var table ResultTable
+
+		if json.Unmarshal(raw, &table) == nil {
+			return &table
+		}
+	}
+
+	var value interface{}
+
+	json.Unmarshal(raw, &value)
+
+	return value
+}
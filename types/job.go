@@ -0,0 +1,34 @@
+package types
+
+import "time"
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+type Job struct {
+	Id       string     `json:"id"`
+	Name     string     `json:"name,omitempty"`
+	Status   string     `json:"status"`
+	Message  string     `json:"message,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Deliveries []WebhookDelivery `json:"deliveries,omitempty"`
+}
+
+type StepEvent struct {
+	Index    int            `json:"index"`
+	Path     string         `json:"path,omitempty"`
+	Page     int            `json:"page"`
+	Kind     string         `json:"kind"`
+	Selector string         `json:"selector,omitempty"`
+	Duration time.Duration  `json:"duration"`
+	Content  *ResultContent `json:"content,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
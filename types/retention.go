@@ -0,0 +1,30 @@
+package types
+
+import "time"
+
+// Retention limits how long and how much artifacts of the flow are kept,
+// max age accepts Go duration or days such as 30d
+type Retention struct {
+	MaxAge   string `yaml:"max_age" json:"max_age,omitempty"`
+	MaxBytes int64  `yaml:"max_bytes" json:"max_bytes,omitempty"`
+}
+
+type RetentionReport struct {
+	Started   time.Time     `json:"started"`
+	Finished  time.Time     `json:"finished"`
+	Duration  time.Duration `json:"duration"`
+	Runs      int           `json:"runs"`
+	Artifacts int           `json:"artifacts"`
+	Orphans   int           `json:"orphans"`
+	Reclaimed float64       `json:"reclaimed"`
+	Errors    []string      `json:"errors,omitempty"`
+}
+
+type RetentionStatus struct {
+	Enabled   bool             `json:"enabled"`
+	Policy    Retention        `json:"policy"`
+	Interval  string           `json:"interval"`
+	Next      *time.Time       `json:"next,omitempty"`
+	Reclaimed float64          `json:"reclaimed"`
+	Last      *RetentionReport `json:"last,omitempty"`
+}
@@ -0,0 +1,36 @@
+package types
+
+import "time"
+
+type Run struct {
+	Id         string            `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Owner      string            `json:"owner,omitempty"`
+	Status     string            `json:"status"`
+	Version    string            `json:"version"`
+	Config     Config            `json:"config"`
+	Result     *Result           `json:"result,omitempty"`
+	Errors     []string          `json:"errors,omitempty"`
+	Usage      ResultUsage       `json:"usage"`
+	Artifacts  []string          `json:"artifacts,omitempty"`
+	Deliveries []WebhookDelivery `json:"deliveries,omitempty"`
+	Expired    *time.Time        `json:"expired,omitempty"`
+	Reclaimed  float64           `json:"reclaimed,omitempty"`
+	Created    time.Time         `json:"created"`
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	Duration   time.Duration     `json:"duration"`
+}
+
+type Artifact struct {
+	Key string `json:"key"`
+	Url string `json:"url"`
+}
+
+type RunFilter struct {
+	Owner  string
+	Name   string
+	Status string
+	Since  time.Time
+	Limit  int
+}
@@ -0,0 +1,33 @@
+package types
+
+import "time"
+
+const (
+	MissedSkip    = "skip"
+	MissedCatchUp = "catch_up"
+)
+
+type Schedule struct {
+	Cron     string `yaml:"cron" json:"cron"`
+	Timezone string `yaml:"timezone" json:"timezone"`
+	Missed   string `yaml:"missed" json:"missed"`
+	Jitter   int    `yaml:"jitter" json:"jitter"`
+	Owner    string `yaml:"owner" json:"owner"`
+}
+
+type ScheduleStatus struct {
+	Id       string     `json:"id"`
+	Name     string     `json:"name"`
+	Cron     string     `json:"cron"`
+	Timezone string     `json:"timezone"`
+	Missed   string     `json:"missed"`
+	Jitter   int        `json:"jitter"`
+	Source   string     `json:"source"`
+	Owner    string     `json:"owner,omitempty"`
+	Next     time.Time  `json:"next"`
+	Last     *time.Time `json:"last,omitempty"`
+	LastJob  string     `json:"last_job,omitempty"`
+	Running  bool       `json:"running"`
+	Pending  bool       `json:"pending"`
+	Skipped  int        `json:"skipped"`
+}
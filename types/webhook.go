@@ -0,0 +1,27 @@
+package types
+
+import "time"
+
+type Webhook struct {
+	Url    string   `yaml:"url" json:"url"`
+	Secret string   `yaml:"secret" json:"secret"`
+	Events []string `yaml:"events" json:"events"`
+}
+
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	Id        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Result    *Result   `json:"result,omitempty"`
+}
+
+type WebhookDelivery struct {
+	Event    string        `json:"event"`
+	Attempt  int           `json:"attempt"`
+	Status   int           `json:"status,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Sent     time.Time     `json:"sent"`
+}
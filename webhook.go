@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"engine/lib"
+	"engine/types"
+
+	"github.com/fatih/color"
+)
+
+/**
+ * Function to name the final state of the flow, also used as the webhook event
+ */
+func ResultStatus(result types.Result) string {
+	if result.Cancelled {
+		return types.JobCancelled
+	}
+
+	if result.Code == 200 {
+		return types.JobSucceeded
+	}
+
+	return types.JobFailed
+}
+
+/**
+ * Function to post the final result into the webhook of the flow and return the delivery log
+ */
+func Callback(request types.Config, result types.Result) []types.WebhookDelivery {
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	event := ResultStatus(result)
+
+	if !lib.Subscribed(request.Webhook, event) {
+		return nil
+	}
+
+	payload := types.WebhookPayload{
+		Event:     event,
+		Id:        result.Id,
+		Name:      request.Name,
+		Message:   result.Message,
+		Timestamp: time.Now(),
+		Result:    &result,
+	}
+
+	deliveries := lib.NewWebhook().Deliver(context.Background(), request.Webhook, payload)
+
+	for _, delivery := range deliveries {
+		if delivery.Error != "" {
+			log.Printf(red("[ Engine ] Webhook %s for #%s attempt %d failed, due to %s"), event, result.Id, delivery.Attempt, delivery.Error)
+		} else {
+			log.Printf("%s Webhook %s for #%s delivered on attempt %d", yellow("[ Engine ]"), event, result.Id, delivery.Attempt)
+		}
+	}
+
+	return deliveries
+}